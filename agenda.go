@@ -0,0 +1,39 @@
+package main
+
+import (
+	"database/sql"
+)
+
+type AgendaItem struct {
+	Id    int
+	Title string
+}
+
+/* Load the agenda of a meeting, in order */
+func LoadAgenda(db *sql.DB, meetingid int) ([]AgendaItem, error) {
+	rows, err := db.Query("SELECT id, title FROM membership_meetingagendaitem WHERE meeting_id=$1 ORDER BY sortkey, id", meetingid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	agenda := []AgendaItem{}
+	for rows.Next() {
+		var item AgendaItem
+		if err := rows.Scan(&item.Id, &item.Title); err != nil {
+			return nil, err
+		}
+		agenda = append(agenda, item)
+	}
+	return agenda, rows.Err()
+}
+
+/* Find the position of an item in the agenda, or -1 if it's not there */
+func AgendaIndex(agenda []AgendaItem, id int) int {
+	for i, item := range agenda {
+		if item.Id == id {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,137 @@
+package main
+
+import (
+	"database/sql"
+	"time"
+)
+
+/*
+ * Attendance register. Every connection of a member to a meeting is
+ * recorded as an interval in the database, which is what our bylaws
+ * require as the record of who was present and when.
+ */
+
+type AttendanceRecord struct {
+	KeyId     int
+	Name      string
+	Proxy     string
+	Connected bool
+	Total     time.Duration
+	Polls     []int
+}
+
+/* Start a new attendance interval, returning its id */
+func StartAttendance(db *sql.DB, meetingid int, keyid int, proxyname *string) (int, error) {
+	var id int
+	row := db.QueryRow("INSERT INTO membership_meetingattendance(meeting_id, key_id, proxyname, joined_at) VALUES ($1, $2, $3, CURRENT_TIMESTAMP) RETURNING id", meetingid, keyid, proxyname)
+	err := row.Scan(&id)
+	return id, err
+}
+
+/* Has the member with this key been present in the meeting at any time */
+func HasAttended(db *sql.DB, meetingid int, keyid int) (bool, error) {
+	var attended bool
+	row := db.QueryRow("SELECT EXISTS (SELECT 1 FROM membership_meetingattendance WHERE meeting_id=$1 AND key_id=$2)", meetingid, keyid)
+	err := row.Scan(&attended)
+	return attended, err
+}
+
+/* End an attendance interval */
+func EndAttendance(db *sql.DB, attendanceid int) error {
+	_, err := db.Exec("UPDATE membership_meetingattendance SET left_at=CURRENT_TIMESTAMP WHERE id=$1 AND left_at IS NULL", attendanceid)
+	return err
+}
+
+/*
+ * Close any intervals left open by a server that went away without seeing
+ * the members leave. We don't know when they actually left, so use the last
+ * thing that happened in the meeting as the best approximation.
+ */
+func CloseDanglingAttendance(db *sql.DB, meetingid int) error {
+	_, err := db.Exec(`UPDATE membership_meetingattendance
+SET left_at=GREATEST(joined_at, (SELECT max(t) FROM membership_meetingmessagelog WHERE meeting_id=$1))
+WHERE meeting_id=$1 AND left_at IS NULL`, meetingid)
+	return err
+}
+
+/*
+ * Load the attendance of all members who have been in the meeting, in the
+ * order they first joined. Intervals that are still open count until now.
+ * A member is present at a poll if they were connected when it was opened.
+ */
+func LoadAttendance(db *sql.DB, meetingid int) ([]*AttendanceRecord, error) {
+	pollrows, err := db.Query("SELECT (eventparams->>'poll')::int, t FROM membership_meetingmessagelog WHERE meeting_id=$1 AND eventtype=$2 ORDER BY id", meetingid, EventPollOpen)
+	if err != nil {
+		return nil, err
+	}
+	defer pollrows.Close()
+
+	type pollTime struct {
+		id int
+		t  time.Time
+	}
+	var polls []pollTime
+	for pollrows.Next() {
+		var p pollTime
+		if err := pollrows.Scan(&p.id, &p.t); err != nil {
+			return nil, err
+		}
+		polls = append(polls, p)
+	}
+	if err := pollrows.Err(); err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query(`SELECT a.key_id, COALESCE(m.fullname, ''), COALESCE(a.proxyname, ''), a.joined_at, a.left_at
+FROM membership_meetingattendance a
+INNER JOIN membership_membermeetingkey mk ON mk.id=a.key_id
+INNER JOIN membership_member m ON m.user_id=mk.member_id
+WHERE a.meeting_id=$1
+ORDER BY a.joined_at, a.id`, meetingid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	now := time.Now()
+	var records []*AttendanceRecord
+	bykey := make(map[int]*AttendanceRecord)
+	present := make(map[int]map[int]bool)
+	for rows.Next() {
+		var keyid int
+		var name, proxy string
+		var joined time.Time
+		var left sql.NullTime
+		if err := rows.Scan(&keyid, &name, &proxy, &joined, &left); err != nil {
+			return nil, err
+		}
+
+		r, ok := bykey[keyid]
+		if !ok {
+			r = &AttendanceRecord{KeyId: keyid, Name: name, Polls: []int{}}
+			bykey[keyid] = r
+			present[keyid] = make(map[int]bool)
+			records = append(records, r)
+		}
+		/* The most recent proxy is the one that counts */
+		if proxy != "" {
+			r.Proxy = proxy
+		}
+
+		end := now
+		if left.Valid {
+			end = left.Time
+		} else {
+			r.Connected = true
+		}
+		r.Total += end.Sub(joined)
+
+		for _, p := range polls {
+			if !p.t.Before(joined) && !p.t.After(end) && !present[keyid][p.id] {
+				present[keyid][p.id] = true
+				r.Polls = append(r.Polls, p.id)
+			}
+		}
+	}
+	return records, rows.Err()
+}
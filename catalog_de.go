@@ -0,0 +1,120 @@
+package main
+
+/* German message catalog */
+var catalogDe = map[string]string{
+	/* Events */
+	`Member {{.name}} {{if .rejoin}}re-{{end}}joined the meeting{{with .proxy}} (through proxy {{.}}){{end}}`: `Mitglied {{.name}} ist der Versammlung {{if .rejoin}}erneut {{end}}beigetreten{{with .proxy}} (vertreten durch {{.}}){{end}}`,
+	`Member {{.name}} left the meeting`:                              `Mitglied {{.name}} hat die Versammlung verlassen`,
+	`This meeting is being re-opened by {{.byname}}`:                 `Diese Versammlung wird von {{.byname}} wieder eröffnet`,
+	`This meeting is now open`:                                       `Diese Versammlung ist jetzt eröffnet`,
+	`Anything sent from now on will be part of the permanent record`: `Alles, was ab jetzt gesendet wird, wird Teil des dauerhaften Protokolls`,
+	`This meeting is now finished`:                                   `Diese Versammlung ist jetzt beendet`,
+	`Slow mode has been enabled by {{.byname}}, members can post one message every {{.seconds}} seconds`:                                         `Der langsame Modus wurde von {{.byname}} aktiviert, Mitglieder können alle {{.seconds}} Sekunden eine Nachricht senden`,
+	`Slow mode has been disabled by {{.byname}}`:                                                                                                 `Der langsame Modus wurde von {{.byname}} deaktiviert`,
+	`A new poll has been posted for {{.question}}`:                                                                                               `Eine neue Abstimmung wurde gestartet: {{.question}}`,
+	`{{.name}} {{if .changed}}changed their vote to{{else}}voted{{end}} {{.answertext}}{{if .paper}} (paper vote entered by {{.byname}}){{end}}`: `{{.name}} hat {{if .changed}}neu {{end}}abgestimmt: {{.answertext}}{{if .paper}} (Stimmzettel eingetragen von {{.byname}}){{end}}`,
+	`{{if eq .reason "allvoted"}}All attendees have voted, poll has completed.{{else}}Poll has completed{{end}}`:                                 `{{if eq .reason "allvoted"}}Alle Teilnehmenden haben abgestimmt, die Abstimmung ist abgeschlossen.{{else}}Die Abstimmung ist abgeschlossen{{end}}`,
+	`Answer "{{.answertext}}": {{.votes}} vote{{if ne .votes 1}}s{{end}}`:                                                                        `Antwort „{{.answertext}}“: {{.votes}} {{if eq .votes 1}}Stimme{{else}}Stimmen{{end}}`,
+	`The current poll has has been aborted`:                                                                                                      `Die laufende Abstimmung wurde abgebrochen`,
+	`User {{.name}} has been disconnected by {{.byname}}`:                                                                                        `{{.name}} wurde von {{.byname}} aus der Versammlung entfernt`,
+	`User {{.name}} has been muted by {{.byname}}`:                                                                                               `{{.name}} wurde von {{.byname}} stummgeschaltet`,
+	`User {{.name}} has been unmuted by {{.byname}}`:                                                                                             `Die Stummschaltung von {{.name}} wurde von {{.byname}} aufgehoben`,
+	`{{.byname}} pinned a message`:                                                                                                               `{{.byname}} hat eine Nachricht angeheftet`,
+	`{{.byname}} unpinned a message`:                                                                                                             `{{.byname}} hat eine Nachricht gelöst`,
+	`Q&A has been opened by {{.byname}}, questions can now be submitted`:                                                                         `Die Fragerunde wurde von {{.byname}} eröffnet, Fragen können jetzt gestellt werden`,
+	`Q&A has been closed by {{.byname}}`:                                                                                                         `Die Fragerunde wurde von {{.byname}} geschlossen`,
+	`This meeting is scheduled to finish in {{.minutes}} minute{{if ne .minutes 1}}s{{end}}, at {{.time}}`:                                       `Diese Versammlung endet planmäßig in {{.minutes}} {{if eq .minutes 1}}Minute{{else}}Minuten{{end}}, um {{.time}}`,
+	`Now discussing agenda item {{.number}}: {{.title}}`:                                                                                         `Jetzt wird Tagesordnungspunkt {{.number}} behandelt: {{.title}}`,
+	"Quorum has been reached, {{.present}} of {{.quorum}} required members are present":                                                          "Die Beschlussfähigkeit ist erreicht, {{.present}} von {{.quorum}} erforderlichen Mitgliedern sind anwesend",
+	"Quorum has been lost, only {{.present}} of {{.quorum}} required members are present":                                                        "Die Beschlussfähigkeit ist verloren, nur {{.present}} von {{.quorum}} erforderlichen Mitgliedern sind anwesend",
+	"The meeting is now in recess{{with .resume}} and will resume at {{.}}{{end}}":                                                               "Die Versammlung ist jetzt unterbrochen{{with .resume}} und wird um {{.}} fortgesetzt{{end}}",
+	"The meeting has been resumed by {{.byname}}":                                                                                                "Die Versammlung wurde von {{.byname}} fortgesetzt",
+	"{{.name}} has been made an administrator by {{.byname}}":                                                                                    "{{.name}} wurde von {{.byname}} zur Versammlungsleitung ernannt",
+	"{{.name}} is no longer an administrator, changed by {{.byname}}":                                                                            "{{.name}} gehört nicht mehr zur Versammlungsleitung, geändert von {{.byname}}",
+
+	/* Disconnects and errors */
+	"Connection error":                                                           "Verbindungsfehler",
+	"You are not allowed to enter this meeting":                                  "Sie dürfen dieser Versammlung nicht beitreten",
+	"This meeting is already finished and can no longer be joined.":              "Diese Versammlung ist bereits beendet, ein Beitritt ist nicht mehr möglich.",
+	"This meeting is already in progress and can no longer be joined.":           "Diese Versammlung läuft bereits, ein Beitritt ist nicht mehr möglich.",
+	"You have connected from a different session. This session is disconnected.": "Sie haben sich aus einer anderen Sitzung verbunden. Diese Sitzung wurde getrennt.",
+	"You have been forcibly disconnected from this meeting":                      "Sie wurden zwangsweise von dieser Versammlung getrennt",
+	"You have been muted and cannot post messages":                               "Sie wurden stummgeschaltet und können keine Nachrichten senden",
+	"Slow mode is enabled, you can post again in %d seconds":                     "Der langsame Modus ist aktiv, Sie können in %d Sekunden wieder schreiben",
+	"You are sending messages too fast, please slow down":                        "Sie senden Nachrichten zu schnell, bitte etwas langsamer",
+	"Permission denied":                    "Zugriff verweigert",
+	"Failed to load message history":       "Der Nachrichtenverlauf konnte nicht geladen werden",
+	"Meeting is already open":              "Die Versammlung ist bereits eröffnet",
+	"Meeting is already finished":          "Die Versammlung ist bereits beendet",
+	"There is already an active poll":      "Es läuft bereits eine Abstimmung",
+	"There is no active poll":              "Es läuft keine Abstimmung",
+	"Vote for the wrong question received": "Stimme für die falsche Frage erhalten",
+	"Invalid vote":                         "Ungültige Stimme",
+	"User to kick not found":               "Zu entfernendes Mitglied nicht gefunden",
+	"User to mute not found":               "Stummzuschaltendes Mitglied nicht gefunden",
+	"User is already muted":                "Das Mitglied ist bereits stummgeschaltet",
+	"User is not muted":                    "Das Mitglied ist nicht stummgeschaltet",
+	"Message not found":                    "Nachricht nicht gefunden",
+	"Message is already pinned":            "Die Nachricht ist bereits angeheftet",
+	"Message is not pinned":                "Die Nachricht ist nicht angeheftet",
+	"Failed to post message":               "Die Nachricht konnte nicht gesendet werden",
+	"Message being replied to not found":   "Die Nachricht, auf die geantwortet wird, wurde nicht gefunden",
+	"Mentioned member not found":           "Erwähntes Mitglied nicht gefunden",
+	"Too many mentions":                    "Zu viele Erwähnungen",
+	"Q&A is already open":                  "Die Fragerunde ist bereits eröffnet",
+	"Q&A is already closed":                "Die Fragerunde ist bereits geschlossen",
+	"Q&A is not open":                      "Die Fragerunde ist nicht eröffnet",
+	"Failed to store question":             "Die Frage konnte nicht gespeichert werden",
+	"Question not found":                   "Frage nicht gefunden",
+	"Question is no longer open":           "Die Frage ist nicht mehr offen",
+	"You cannot upvote your own question":  "Sie können nicht für Ihre eigene Frage stimmen",
+	"Failed to store vote":                 "Die Stimme konnte nicht gespeichert werden",
+	"There are no more agenda items":       "Es gibt keine weiteren Tagesordnungspunkte",
+	"Agenda item not found":                "Tagesordnungspunkt nicht gefunden",
+	"The meeting cannot be opened without quorum, %d of %d required members are present": "Die Versammlung kann ohne Beschlussfähigkeit nicht eröffnet werden, %d von %d erforderlichen Mitgliedern sind anwesend",
+	"This meeting has no quorum set":                      "Für diese Versammlung ist keine Beschlussfähigkeit festgelegt",
+	"Meeting is already in recess":                        "Die Versammlung ist bereits unterbrochen",
+	"Only an open meeting can go into recess":             "Nur eine eröffnete Versammlung kann unterbrochen werden",
+	"Cannot go into recess while a poll is running":       "Während einer laufenden Abstimmung kann die Versammlung nicht unterbrochen werden",
+	"The meeting is in recess, chat is suspended":         "Die Versammlung ist unterbrochen, der Chat ist ausgesetzt",
+	"Voting is suspended during the recess":               "Während der Unterbrechung ist die Abstimmung ausgesetzt",
+	"The server is restarting, please reconnect shortly":  "Der Server wird neu gestartet, bitte verbinden Sie sich in Kürze erneut",
+	"Your access to this meeting has been revoked":        "Ihr Zugang zu dieser Versammlung wurde widerrufen",
+	"This meeting has been closed":                        "Diese Versammlung wurde geschlossen",
+	"User not found":                                      "Benutzer nicht gefunden",
+	"User is already an administrator":                    "Der Benutzer gehört bereits zur Versammlungsleitung",
+	"User is not an administrator":                        "Der Benutzer gehört nicht zur Versammlungsleitung",
+	"Cannot remove the last connected administrator":      "Die letzte verbundene Person der Versammlungsleitung kann nicht entfernt werden",
+	"Failed to update administrators in database":         "Die Versammlungsleitung konnte in der Datenbank nicht aktualisiert werden",
+	"The lobby is already enabled":                        "Der Warteraum ist bereits aktiviert",
+	"The lobby is already disabled":                       "Der Warteraum ist bereits deaktiviert",
+	"Failed to update lobby mode in database":             "Warteraum-Modus konnte nicht in der Datenbank gespeichert werden",
+	"User not found in the lobby":                         "Benutzer nicht im Warteraum gefunden",
+	"You have not been admitted to this meeting":          "Sie wurden nicht zu dieser Versammlung zugelassen",
+	"This meeting is locked and can no longer be joined.": "Diese Versammlung ist gesperrt, ein Beitritt ist nicht mehr möglich.",
+	"The meeting is already locked":                       "Die Versammlung ist bereits gesperrt",
+	"The meeting is not locked":                           "Die Versammlung ist nicht gesperrt",
+	"Failed to update lock in database":                   "Sperre konnte nicht in der Datenbank gespeichert werden",
+	"Failed to update state in database":                  "Status konnte nicht in der Datenbank gespeichert werden",
+	"Failed to update mute state in database":             "Stummschaltung konnte nicht in der Datenbank gespeichert werden",
+	"Failed to update pinned state in database":           "Anheften konnte nicht in der Datenbank gespeichert werden",
+	"Failed to update question in database":               "Frage konnte nicht in der Datenbank gespeichert werden",
+	"Failed to update agenda in database":                 "Tagesordnung konnte nicht in der Datenbank gespeichert werden",
+	"Cannot pin an empty message":                         "Eine leere Nachricht kann nicht angeheftet werden",
+	"You cannot change your own administrator status":     "Sie können Ihre eigene Zugehörigkeit zur Versammlungsleitung nicht ändern",
+
+	/* Minutes */
+	"Date":                         "Datum",
+	"Attendance":                   "Anwesenheit",
+	"through proxy":                "vertreten durch",
+	"Nobody attended this meeting": "Niemand hat an dieser Versammlung teilgenommen",
+	"Proceedings":                  "Ablauf",
+	"General":                      "Allgemeines",
+	"Poll":                         "Abstimmung",
+	"Started at":                   "Gestartet um",
+	"aborted":                      "abgebrochen",
+	"Transcript":                   "Verlauf",
+	"present":                      "anwesend",
+	"polls":                        "Abstimmungen",
+	"Failed to load attendance":    "Die Anwesenheitsliste konnte nicht geladen werden",
+}
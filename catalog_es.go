@@ -0,0 +1,120 @@
+package main
+
+/* Spanish message catalog */
+var catalogEs = map[string]string{
+	/* Events */
+	`Member {{.name}} {{if .rejoin}}re-{{end}}joined the meeting{{with .proxy}} (through proxy {{.}}){{end}}`: `El miembro {{.name}} se ha {{if .rejoin}}vuelto a unir{{else}}unido{{end}} a la reunión{{with .proxy}} (representado por {{.}}){{end}}`,
+	`Member {{.name}} left the meeting`:                              `El miembro {{.name}} ha abandonado la reunión`,
+	`This meeting is being re-opened by {{.byname}}`:                 `{{.byname}} está reabriendo esta reunión`,
+	`This meeting is now open`:                                       `Esta reunión está abierta`,
+	`Anything sent from now on will be part of the permanent record`: `Todo lo que se envíe a partir de ahora formará parte del acta permanente`,
+	`This meeting is now finished`:                                   `Esta reunión ha terminado`,
+	`Slow mode has been enabled by {{.byname}}, members can post one message every {{.seconds}} seconds`:                                         `{{.byname}} ha activado el modo lento, los miembros pueden enviar un mensaje cada {{.seconds}} segundos`,
+	`Slow mode has been disabled by {{.byname}}`:                                                                                                 `{{.byname}} ha desactivado el modo lento`,
+	`A new poll has been posted for {{.question}}`:                                                                                               `Se ha abierto una nueva votación: {{.question}}`,
+	`{{.name}} {{if .changed}}changed their vote to{{else}}voted{{end}} {{.answertext}}{{if .paper}} (paper vote entered by {{.byname}}){{end}}`: `{{.name}} ha {{if .changed}}cambiado su voto a{{else}}votado{{end}} {{.answertext}}{{if .paper}} (voto en papel registrado por {{.byname}}){{end}}`,
+	`{{if eq .reason "allvoted"}}All attendees have voted, poll has completed.{{else}}Poll has completed{{end}}`:                                 `{{if eq .reason "allvoted"}}Todos los asistentes han votado, la votación ha finalizado.{{else}}La votación ha finalizado{{end}}`,
+	`Answer "{{.answertext}}": {{.votes}} vote{{if ne .votes 1}}s{{end}}`:                                                                        `Respuesta "{{.answertext}}": {{.votes}} voto{{if ne .votes 1}}s{{end}}`,
+	`The current poll has has been aborted`:                                                                                                      `La votación en curso ha sido cancelada`,
+	`User {{.name}} has been disconnected by {{.byname}}`:                                                                                        `{{.byname}} ha desconectado a {{.name}}`,
+	`User {{.name}} has been muted by {{.byname}}`:                                                                                               `{{.byname}} ha silenciado a {{.name}}`,
+	`User {{.name}} has been unmuted by {{.byname}}`:                                                                                             `{{.byname}} ha dejado de silenciar a {{.name}}`,
+	`{{.byname}} pinned a message`:                                                                                                               `{{.byname}} ha fijado un mensaje`,
+	`{{.byname}} unpinned a message`:                                                                                                             `{{.byname}} ha dejado de fijar un mensaje`,
+	`Q&A has been opened by {{.byname}}, questions can now be submitted`:                                                                         `{{.byname}} ha abierto el turno de preguntas, ya se pueden enviar preguntas`,
+	`Q&A has been closed by {{.byname}}`:                                                                                                         `{{.byname}} ha cerrado el turno de preguntas`,
+	`This meeting is scheduled to finish in {{.minutes}} minute{{if ne .minutes 1}}s{{end}}, at {{.time}}`:                                       `Esta reunión está programada para terminar en {{.minutes}} minuto{{if ne .minutes 1}}s{{end}}, a las {{.time}}`,
+	`Now discussing agenda item {{.number}}: {{.title}}`:                                                                                         `Ahora se trata el punto {{.number}} del orden del día: {{.title}}`,
+	"Quorum has been reached, {{.present}} of {{.quorum}} required members are present":                                                          "Se ha alcanzado el quórum, están presentes {{.present}} de los {{.quorum}} miembros requeridos",
+	"Quorum has been lost, only {{.present}} of {{.quorum}} required members are present":                                                        "Se ha perdido el quórum, solo están presentes {{.present}} de los {{.quorum}} miembros requeridos",
+	"The meeting is now in recess{{with .resume}} and will resume at {{.}}{{end}}":                                                               "La reunión está ahora en receso{{with .resume}} y se reanudará a las {{.}}{{end}}",
+	"The meeting has been resumed by {{.byname}}":                                                                                                "La reunión ha sido reanudada por {{.byname}}",
+	"{{.name}} has been made an administrator by {{.byname}}":                                                                                    "{{.name}} ha sido nombrado administrador por {{.byname}}",
+	"{{.name}} is no longer an administrator, changed by {{.byname}}":                                                                            "{{.name}} ya no es administrador, cambiado por {{.byname}}",
+
+	/* Disconnects and errors */
+	"Connection error":                                                           "Error de conexión",
+	"You are not allowed to enter this meeting":                                  "No tiene permiso para entrar en esta reunión",
+	"This meeting is already finished and can no longer be joined.":              "Esta reunión ya ha terminado y ya no es posible unirse.",
+	"This meeting is already in progress and can no longer be joined.":           "Esta reunión ya está en curso y ya no es posible unirse.",
+	"You have connected from a different session. This session is disconnected.": "Se ha conectado desde otra sesión. Esta sesión ha sido desconectada.",
+	"You have been forcibly disconnected from this meeting":                      "Ha sido desconectado de esta reunión",
+	"You have been muted and cannot post messages":                               "Ha sido silenciado y no puede enviar mensajes",
+	"Slow mode is enabled, you can post again in %d seconds":                     "El modo lento está activado, podrá escribir de nuevo en %d segundos",
+	"You are sending messages too fast, please slow down":                        "Está enviando mensajes demasiado rápido, por favor vaya más despacio",
+	"Permission denied":                    "Permiso denegado",
+	"Failed to load message history":       "No se pudo cargar el historial de mensajes",
+	"Meeting is already open":              "La reunión ya está abierta",
+	"Meeting is already finished":          "La reunión ya ha terminado",
+	"There is already an active poll":      "Ya hay una votación en curso",
+	"There is no active poll":              "No hay ninguna votación en curso",
+	"Vote for the wrong question received": "Se ha recibido un voto para la pregunta equivocada",
+	"Invalid vote":                         "Voto no válido",
+	"User to kick not found":               "No se encontró al participante a desconectar",
+	"User to mute not found":               "No se encontró al participante a silenciar",
+	"User is already muted":                "El participante ya está silenciado",
+	"User is not muted":                    "El participante no está silenciado",
+	"Message not found":                    "Mensaje no encontrado",
+	"Message is already pinned":            "El mensaje ya está fijado",
+	"Message is not pinned":                "El mensaje no está fijado",
+	"Failed to post message":               "No se pudo enviar el mensaje",
+	"Message being replied to not found":   "No se encontró el mensaje al que responde",
+	"Mentioned member not found":           "No se encontró al miembro mencionado",
+	"Too many mentions":                    "Demasiadas menciones",
+	"Q&A is already open":                  "El turno de preguntas ya está abierto",
+	"Q&A is already closed":                "El turno de preguntas ya está cerrado",
+	"Q&A is not open":                      "El turno de preguntas no está abierto",
+	"Failed to store question":             "No se pudo guardar la pregunta",
+	"Question not found":                   "Pregunta no encontrada",
+	"Question is no longer open":           "La pregunta ya no está abierta",
+	"You cannot upvote your own question":  "No puede votar su propia pregunta",
+	"Failed to store vote":                 "No se pudo guardar el voto",
+	"There are no more agenda items":       "No hay más puntos en el orden del día",
+	"Agenda item not found":                "Punto del orden del día no encontrado",
+	"The meeting cannot be opened without quorum, %d of %d required members are present": "La reunión no puede abrirse sin quórum, están presentes %d de los %d miembros requeridos",
+	"This meeting has no quorum set":                      "Esta reunión no tiene quórum definido",
+	"Meeting is already in recess":                        "La reunión ya está en receso",
+	"Only an open meeting can go into recess":             "Solo una reunión abierta puede entrar en receso",
+	"Cannot go into recess while a poll is running":       "No se puede entrar en receso mientras hay una votación en curso",
+	"The meeting is in recess, chat is suspended":         "La reunión está en receso, el chat está suspendido",
+	"Voting is suspended during the recess":               "La votación está suspendida durante el receso",
+	"The server is restarting, please reconnect shortly":  "El servidor se está reiniciando, vuelva a conectarse en unos momentos",
+	"Your access to this meeting has been revoked":        "Su acceso a esta reunión ha sido revocado",
+	"This meeting has been closed":                        "Esta reunión ha sido cerrada",
+	"User not found":                                      "Usuario no encontrado",
+	"User is already an administrator":                    "El usuario ya es administrador",
+	"User is not an administrator":                        "El usuario no es administrador",
+	"Cannot remove the last connected administrator":      "No se puede quitar al último administrador conectado",
+	"Failed to update administrators in database":         "No se pudieron actualizar los administradores en la base de datos",
+	"The lobby is already enabled":                        "La sala de espera ya está activada",
+	"The lobby is already disabled":                       "La sala de espera ya está desactivada",
+	"Failed to update lobby mode in database":             "No se pudo guardar el modo de sala de espera en la base de datos",
+	"User not found in the lobby":                         "Usuario no encontrado en la sala de espera",
+	"You have not been admitted to this meeting":          "No ha sido admitido en esta reunión",
+	"This meeting is locked and can no longer be joined.": "Esta reunión está bloqueada y ya no es posible unirse.",
+	"The meeting is already locked":                       "La reunión ya está bloqueada",
+	"The meeting is not locked":                           "La reunión no está bloqueada",
+	"Failed to update lock in database":                   "No se pudo guardar el bloqueo en la base de datos",
+	"Failed to update state in database":                  "No se pudo guardar el estado en la base de datos",
+	"Failed to update mute state in database":             "No se pudo guardar el silenciamiento en la base de datos",
+	"Failed to update pinned state in database":           "No se pudo guardar el fijado en la base de datos",
+	"Failed to update question in database":               "No se pudo guardar la pregunta en la base de datos",
+	"Failed to update agenda in database":                 "No se pudo guardar el orden del día en la base de datos",
+	"Cannot pin an empty message":                         "No se puede fijar un mensaje vacío",
+	"You cannot change your own administrator status":     "No puede cambiar su propio estado de administrador",
+
+	/* Minutes */
+	"Date":                         "Fecha",
+	"Attendance":                   "Asistencia",
+	"through proxy":                "representado por",
+	"Nobody attended this meeting": "Nadie asistió a esta reunión",
+	"Proceedings":                  "Desarrollo",
+	"General":                      "General",
+	"Poll":                         "Votación",
+	"Started at":                   "Iniciada a las",
+	"aborted":                      "cancelada",
+	"Transcript":                   "Transcripción",
+	"present":                      "presentes",
+	"polls":                        "votaciones",
+	"Failed to load attendance":    "No se pudo cargar la lista de asistencia",
+}
@@ -0,0 +1,120 @@
+package main
+
+/* French message catalog */
+var catalogFr = map[string]string{
+	/* Events */
+	`Member {{.name}} {{if .rejoin}}re-{{end}}joined the meeting{{with .proxy}} (through proxy {{.}}){{end}}`: `Le membre {{.name}} a {{if .rejoin}}de nouveau {{end}}rejoint la réunion{{with .proxy}} (représenté par {{.}}){{end}}`,
+	`Member {{.name}} left the meeting`:                              `Le membre {{.name}} a quitté la réunion`,
+	`This meeting is being re-opened by {{.byname}}`:                 `Cette réunion est rouverte par {{.byname}}`,
+	`This meeting is now open`:                                       `Cette réunion est maintenant ouverte`,
+	`Anything sent from now on will be part of the permanent record`: `Tout ce qui est envoyé à partir de maintenant fera partie du compte rendu permanent`,
+	`This meeting is now finished`:                                   `Cette réunion est maintenant terminée`,
+	`Slow mode has been enabled by {{.byname}}, members can post one message every {{.seconds}} seconds`:                                         `Le mode lent a été activé par {{.byname}}, les membres peuvent envoyer un message toutes les {{.seconds}} secondes`,
+	`Slow mode has been disabled by {{.byname}}`:                                                                                                 `Le mode lent a été désactivé par {{.byname}}`,
+	`A new poll has been posted for {{.question}}`:                                                                                               `Un nouveau vote a été ouvert : {{.question}}`,
+	`{{.name}} {{if .changed}}changed their vote to{{else}}voted{{end}} {{.answertext}}{{if .paper}} (paper vote entered by {{.byname}}){{end}}`: `{{.name}} a {{if .changed}}changé son vote pour{{else}}voté{{end}} {{.answertext}}{{if .paper}} (vote papier saisi par {{.byname}}){{end}}`,
+	`{{if eq .reason "allvoted"}}All attendees have voted, poll has completed.{{else}}Poll has completed{{end}}`:                                 `{{if eq .reason "allvoted"}}Tous les participants ont voté, le vote est terminé.{{else}}Le vote est terminé{{end}}`,
+	`Answer "{{.answertext}}": {{.votes}} vote{{if ne .votes 1}}s{{end}}`:                                                                        `Réponse « {{.answertext}} » : {{.votes}} voix`,
+	`The current poll has has been aborted`:                                                                                                      `Le vote en cours a été annulé`,
+	`User {{.name}} has been disconnected by {{.byname}}`:                                                                                        `{{.name}} a été déconnecté par {{.byname}}`,
+	`User {{.name}} has been muted by {{.byname}}`:                                                                                               `{{.name}} a été mis en sourdine par {{.byname}}`,
+	`User {{.name}} has been unmuted by {{.byname}}`:                                                                                             `La mise en sourdine de {{.name}} a été levée par {{.byname}}`,
+	`{{.byname}} pinned a message`:                                                                                                               `{{.byname}} a épinglé un message`,
+	`{{.byname}} unpinned a message`:                                                                                                             `{{.byname}} a désépinglé un message`,
+	`Q&A has been opened by {{.byname}}, questions can now be submitted`:                                                                         `La séance de questions a été ouverte par {{.byname}}, les questions peuvent maintenant être posées`,
+	`Q&A has been closed by {{.byname}}`:                                                                                                         `La séance de questions a été fermée par {{.byname}}`,
+	`This meeting is scheduled to finish in {{.minutes}} minute{{if ne .minutes 1}}s{{end}}, at {{.time}}`:                                       `Cette réunion doit se terminer dans {{.minutes}} minute{{if ne .minutes 1}}s{{end}}, à {{.time}}`,
+	`Now discussing agenda item {{.number}}: {{.title}}`:                                                                                         `Point {{.number}} de l'ordre du jour en discussion : {{.title}}`,
+	"Quorum has been reached, {{.present}} of {{.quorum}} required members are present":                                                          "Le quorum est atteint, {{.present}} membres présents sur {{.quorum}} requis",
+	"Quorum has been lost, only {{.present}} of {{.quorum}} required members are present":                                                        "Le quorum n'est plus atteint, seulement {{.present}} membres présents sur {{.quorum}} requis",
+	"The meeting is now in recess{{with .resume}} and will resume at {{.}}{{end}}":                                                               "La réunion est maintenant suspendue{{with .resume}} et reprendra à {{.}}{{end}}",
+	"The meeting has been resumed by {{.byname}}":                                                                                                "La réunion a été reprise par {{.byname}}",
+	"{{.name}} has been made an administrator by {{.byname}}":                                                                                    "{{.name}} a été nommé administrateur par {{.byname}}",
+	"{{.name}} is no longer an administrator, changed by {{.byname}}":                                                                            "{{.name}} n'est plus administrateur, modifié par {{.byname}}",
+
+	/* Disconnects and errors */
+	"Connection error":                                                           "Erreur de connexion",
+	"You are not allowed to enter this meeting":                                  "Vous n'êtes pas autorisé à entrer dans cette réunion",
+	"This meeting is already finished and can no longer be joined.":              "Cette réunion est déjà terminée et ne peut plus être rejointe.",
+	"This meeting is already in progress and can no longer be joined.":           "Cette réunion est déjà en cours et ne peut plus être rejointe.",
+	"You have connected from a different session. This session is disconnected.": "Vous vous êtes connecté depuis une autre session. Cette session est déconnectée.",
+	"You have been forcibly disconnected from this meeting":                      "Vous avez été déconnecté de force de cette réunion",
+	"You have been muted and cannot post messages":                               "Vous avez été mis en sourdine et ne pouvez pas envoyer de messages",
+	"Slow mode is enabled, you can post again in %d seconds":                     "Le mode lent est activé, vous pourrez écrire à nouveau dans %d secondes",
+	"You are sending messages too fast, please slow down":                        "Vous envoyez des messages trop rapidement, veuillez ralentir",
+	"Permission denied":                    "Permission refusée",
+	"Failed to load message history":       "Impossible de charger l'historique des messages",
+	"Meeting is already open":              "La réunion est déjà ouverte",
+	"Meeting is already finished":          "La réunion est déjà terminée",
+	"There is already an active poll":      "Un vote est déjà en cours",
+	"There is no active poll":              "Aucun vote n'est en cours",
+	"Vote for the wrong question received": "Vote reçu pour la mauvaise question",
+	"Invalid vote":                         "Vote invalide",
+	"User to kick not found":               "Participant à déconnecter introuvable",
+	"User to mute not found":               "Participant à mettre en sourdine introuvable",
+	"User is already muted":                "Le participant est déjà en sourdine",
+	"User is not muted":                    "Le participant n'est pas en sourdine",
+	"Message not found":                    "Message introuvable",
+	"Message is already pinned":            "Le message est déjà épinglé",
+	"Message is not pinned":                "Le message n'est pas épinglé",
+	"Failed to post message":               "Impossible d'envoyer le message",
+	"Message being replied to not found":   "Le message auquel vous répondez est introuvable",
+	"Mentioned member not found":           "Membre mentionné introuvable",
+	"Too many mentions":                    "Trop de mentions",
+	"Q&A is already open":                  "La séance de questions est déjà ouverte",
+	"Q&A is already closed":                "La séance de questions est déjà fermée",
+	"Q&A is not open":                      "La séance de questions n'est pas ouverte",
+	"Failed to store question":             "Impossible d'enregistrer la question",
+	"Question not found":                   "Question introuvable",
+	"Question is no longer open":           "La question n'est plus ouverte",
+	"You cannot upvote your own question":  "Vous ne pouvez pas soutenir votre propre question",
+	"Failed to store vote":                 "Impossible d'enregistrer le vote",
+	"There are no more agenda items":       "Il n'y a plus de points à l'ordre du jour",
+	"Agenda item not found":                "Point de l'ordre du jour introuvable",
+	"The meeting cannot be opened without quorum, %d of %d required members are present": "La réunion ne peut pas être ouverte sans quorum, %d membres présents sur %d requis",
+	"This meeting has no quorum set":                      "Aucun quorum n'est défini pour cette réunion",
+	"Meeting is already in recess":                        "La réunion est déjà suspendue",
+	"Only an open meeting can go into recess":             "Seule une réunion ouverte peut être suspendue",
+	"Cannot go into recess while a poll is running":       "Impossible de suspendre la réunion pendant un vote",
+	"The meeting is in recess, chat is suspended":         "La réunion est suspendue, le chat est désactivé",
+	"Voting is suspended during the recess":               "Le vote est suspendu pendant la pause",
+	"The server is restarting, please reconnect shortly":  "Le serveur redémarre, veuillez vous reconnecter dans quelques instants",
+	"Your access to this meeting has been revoked":        "Votre accès à cette réunion a été révoqué",
+	"This meeting has been closed":                        "Cette réunion a été clôturée",
+	"User not found":                                      "Utilisateur introuvable",
+	"User is already an administrator":                    "L'utilisateur est déjà administrateur",
+	"User is not an administrator":                        "L'utilisateur n'est pas administrateur",
+	"Cannot remove the last connected administrator":      "Impossible de retirer le dernier administrateur connecté",
+	"Failed to update administrators in database":         "Impossible de mettre à jour les administrateurs dans la base de données",
+	"The lobby is already enabled":                        "La salle d'attente est déjà activée",
+	"The lobby is already disabled":                       "La salle d'attente est déjà désactivée",
+	"Failed to update lobby mode in database":             "Impossible d'enregistrer le mode salle d'attente dans la base de données",
+	"User not found in the lobby":                         "Utilisateur introuvable dans la salle d'attente",
+	"You have not been admitted to this meeting":          "Vous n'avez pas été admis à cette réunion",
+	"This meeting is locked and can no longer be joined.": "Cette réunion est verrouillée et ne peut plus être rejointe.",
+	"The meeting is already locked":                       "La réunion est déjà verrouillée",
+	"The meeting is not locked":                           "La réunion n'est pas verrouillée",
+	"Failed to update lock in database":                   "Impossible d'enregistrer le verrouillage dans la base de données",
+	"Failed to update state in database":                  "Impossible d'enregistrer l'état dans la base de données",
+	"Failed to update mute state in database":             "Impossible d'enregistrer la mise en sourdine dans la base de données",
+	"Failed to update pinned state in database":           "Impossible d'enregistrer l'épinglage dans la base de données",
+	"Failed to update question in database":               "Impossible d'enregistrer la question dans la base de données",
+	"Failed to update agenda in database":                 "Impossible d'enregistrer l'ordre du jour dans la base de données",
+	"Cannot pin an empty message":                         "Impossible d'épingler un message vide",
+	"You cannot change your own administrator status":     "Vous ne pouvez pas modifier votre propre statut d'administrateur",
+
+	/* Minutes */
+	"Date":                         "Date",
+	"Attendance":                   "Présences",
+	"through proxy":                "représenté par",
+	"Nobody attended this meeting": "Personne n'a assisté à cette réunion",
+	"Proceedings":                  "Déroulement",
+	"General":                      "Général",
+	"Poll":                         "Vote",
+	"Started at":                   "Ouvert à",
+	"aborted":                      "annulé",
+	"Transcript":                   "Transcription",
+	"present":                      "présents",
+	"polls":                        "votes",
+	"Failed to load attendance":    "Impossible de charger la liste de présence",
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"log"
+)
+
+/*
+ * System events. Each system message is stored and sent with an event type
+ * and a set of parameters, so clients can style, filter and translate them.
+ * The text is rendered from a template for compatibility with clients that
+ * only show the message itself.
+ */
+const (
+	EventMemberJoin           = "memberjoin"
+	EventMemberLeave          = "memberleave"
+	EventMeetingReopen        = "meetingreopen"
+	EventMeetingOpen          = "meetingopen"
+	EventMeetingRecord        = "meetingrecord"
+	EventMeetingFinish        = "meetingfinish"
+	EventSlowModeEnabled      = "slowmodeenabled"
+	EventSlowModeDisabled     = "slowmodedisabled"
+	EventPollOpen             = "pollopen"
+	EventPollVote             = "pollvote"
+	EventPollClose            = "pollclose"
+	EventPollResult           = "pollresult"
+	EventPollAbort            = "pollabort"
+	EventMemberKick           = "memberkick"
+	EventMemberMute           = "membermute"
+	EventMemberUnmute         = "memberunmute"
+	EventMessagePin           = "messagepin"
+	EventMessageUnpin         = "messageunpin"
+	EventQAOpen               = "qaopen"
+	EventQAClose              = "qaclose"
+	EventMeetingFinishWarning = "meetingfinishwarning"
+	EventAgendaItem           = "agendaitem"
+	EventQuorumReached        = "quorumreached"
+	EventQuorumLost           = "quorumlost"
+	EventMeetingRecess        = "meetingrecess"
+	EventMeetingResume        = "meetingresume"
+	EventMemberPromote        = "memberpromote"
+	EventMemberDemote         = "memberdemote"
+)
+
+/* Reasons for a poll being closed, in the EventPollClose event */
+const (
+	PollCloseAllVoted = "allvoted"
+	PollCloseTimeout  = "timeout"
+)
+
+/* Parameters of an event, which must survive a round trip through json */
+type EventParams map[string]interface{}
+
+/* Templates used to render the text of each event */
+var eventTexts = map[string]string{
+	EventMemberJoin:           `Member {{.name}} {{if .rejoin}}re-{{end}}joined the meeting{{with .proxy}} (through proxy {{.}}){{end}}`,
+	EventMemberLeave:          `Member {{.name}} left the meeting`,
+	EventMeetingReopen:        `This meeting is being re-opened by {{.byname}}`,
+	EventMeetingOpen:          `This meeting is now open`,
+	EventMeetingRecord:        `Anything sent from now on will be part of the permanent record`,
+	EventMeetingFinish:        `This meeting is now finished`,
+	EventSlowModeEnabled:      `Slow mode has been enabled by {{.byname}}, members can post one message every {{.seconds}} seconds`,
+	EventSlowModeDisabled:     `Slow mode has been disabled by {{.byname}}`,
+	EventPollOpen:             `A new poll has been posted for {{.question}}`,
+	EventPollVote:             `{{.name}} {{if .changed}}changed their vote to{{else}}voted{{end}} {{.answertext}}{{if .paper}} (paper vote entered by {{.byname}}){{end}}`,
+	EventPollClose:            `{{if eq .reason "allvoted"}}All attendees have voted, poll has completed.{{else}}Poll has completed{{end}}`,
+	EventPollResult:           `Answer "{{.answertext}}": {{.votes}} vote{{if ne .votes 1}}s{{end}}`,
+	EventPollAbort:            `The current poll has has been aborted`,
+	EventMemberKick:           `User {{.name}} has been disconnected by {{.byname}}`,
+	EventMemberMute:           `User {{.name}} has been muted by {{.byname}}`,
+	EventMemberUnmute:         `User {{.name}} has been unmuted by {{.byname}}`,
+	EventMessagePin:           `{{.byname}} pinned a message`,
+	EventMessageUnpin:         `{{.byname}} unpinned a message`,
+	EventQAOpen:               `Q&A has been opened by {{.byname}}, questions can now be submitted`,
+	EventQAClose:              `Q&A has been closed by {{.byname}}`,
+	EventMeetingFinishWarning: `This meeting is scheduled to finish in {{.minutes}} minute{{if ne .minutes 1}}s{{end}}, at {{.time}}`,
+	EventAgendaItem:           `Now discussing agenda item {{.number}}: {{.title}}`,
+	EventQuorumReached:        `Quorum has been reached, {{.present}} of {{.quorum}} required members are present`,
+	EventQuorumLost:           `Quorum has been lost, only {{.present}} of {{.quorum}} required members are present`,
+	EventMeetingRecess:        `The meeting is now in recess{{with .resume}} and will resume at {{.}}{{end}}`,
+	EventMeetingResume:        `The meeting has been resumed by {{.byname}}`,
+	EventMemberPromote:        `{{.name}} has been made an administrator by {{.byname}}`,
+	EventMemberDemote:         `{{.name}} is no longer an administrator, changed by {{.byname}}`,
+}
+
+/*
+ * Numbers come back from json as float64, which cannot be compared to the
+ * integer constants in the templates, so turn whole numbers back into ints.
+ */
+func normalizeEventParams(params EventParams) EventParams {
+	for k, v := range params {
+		if f, ok := v.(float64); ok && f == float64(int(f)) {
+			params[k] = int(f)
+		}
+	}
+	return params
+}
+
+/* Render the text of an event in the specified language */
+func renderEvent(lang string, eventtype string, params EventParams) string {
+	templates, ok := eventTemplates[lang]
+	if !ok {
+		templates = eventTemplates[DefaultLanguage]
+	}
+	t, ok := templates[eventtype]
+	if !ok {
+		log.Printf("Unknown event type %s", eventtype)
+		return ""
+	}
+
+	var b bytes.Buffer
+	if err := t.Execute(&b, params); err != nil {
+		log.Printf("Failed to render event %s: %s", eventtype, err)
+		return ""
+	}
+	return b.String()
+}
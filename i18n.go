@@ -0,0 +1,56 @@
+package main
+
+import (
+	"text/template"
+)
+
+/*
+ * Message catalogs for server generated text. Each catalog maps the English
+ * text, which is also used as the key, to its translation. Anything not
+ * found in the catalog is sent in English, which means texts only meant for
+ * client developers (such as reports of malformed json) need no translation.
+ */
+const DefaultLanguage = "en"
+
+var catalogs = map[string]map[string]string{
+	"de": catalogDe,
+	"fr": catalogFr,
+	"es": catalogEs,
+}
+
+/* Templates used to render events, per language and event type */
+var eventTemplates = make(map[string]map[string]*template.Template)
+
+func init() {
+	eventTemplates[DefaultLanguage] = parseEventTemplates(DefaultLanguage)
+	for lang := range catalogs {
+		eventTemplates[lang] = parseEventTemplates(lang)
+	}
+}
+
+func parseEventTemplates(lang string) map[string]*template.Template {
+	templates := make(map[string]*template.Template)
+	for k, v := range eventTexts {
+		templates[k] = template.Must(template.New(k).Parse(translate(lang, v)))
+	}
+	return templates
+}
+
+/* Is this a language we have a catalog for */
+func isKnownLanguage(lang string) bool {
+	if lang == DefaultLanguage {
+		return true
+	}
+	_, ok := catalogs[lang]
+	return ok
+}
+
+/* Translate a text into the specified language, falling back to English */
+func translate(lang string, msgid string) string {
+	if c, ok := catalogs[lang]; ok {
+		if t, ok := c[msgid]; ok {
+			return t
+		}
+	}
+	return msgid
+}
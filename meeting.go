@@ -2,9 +2,12 @@ package main
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 	"log"
+	"sort"
+	"strings"
 	"time"
 )
 
@@ -16,6 +19,24 @@ const (
 	ActionNewPoll
 	ActionAbortPoll
 	ActionKickUser
+	ActionMuteUser
+	ActionSlowMode
+	ActionHistory
+	ActionPinMessage
+	ActionTyping
+	ActionQAOpenClose
+	ActionAskQuestion
+	ActionUpvoteQuestion
+	ActionMarkQuestion
+	ActionAgendaItem
+	ActionAttendance
+	ActionRequireQuorum
+	ActionRecess
+	ActionSetAdmin
+	ActionPaperVote
+	ActionLobby
+	ActionAdmit
+	ActionLock
 )
 
 /* Action passed to the Useraction channel */
@@ -27,23 +48,87 @@ type MeetingUseraction struct {
 	open         bool
 	answers      []string
 	minutes      int
+	seconds      int
+	messageid    int
+	mentions     []int
 	targetuserid int
 }
 
+/*
+ * Permission required for each privileged action. This is checked in the
+ * meeting goroutine, which owns the permissions of the users, so a change
+ * of permissions applies to the very next action.
+ */
+type actionPermission struct {
+	permission Permission
+	what       string
+}
+
+var actionPermissions = map[int]actionPermission{
+	ActionOpenFinish:    {PermissionState, "open/finish meeting"},
+	ActionRecess:        {PermissionState, "put meeting in recess"},
+	ActionRequireQuorum: {PermissionState, "require quorum"},
+	ActionAgendaItem:    {PermissionAgenda, "change agenda item"},
+	ActionNewPoll:       {PermissionPolls, "create new poll"},
+	ActionAbortPoll:     {PermissionPolls, "abort running poll"},
+	ActionPaperVote:     {PermissionVoters, "enter paper vote"},
+	ActionKickUser:      {PermissionModerate, "kick another user"},
+	ActionMuteUser:      {PermissionModerate, "mute/unmute another user"},
+	ActionSlowMode:      {PermissionModerate, "change slow mode"},
+	ActionQAOpenClose:   {PermissionModerate, "open/close Q&A"},
+	ActionMarkQuestion:  {PermissionModerate, "mark question"},
+	ActionPinMessage:    {PermissionPin, "pin/unpin message"},
+	ActionAttendance:    {PermissionRecord, "view attendance"},
+	ActionSetAdmin:      {PermissionAdmins, "promote/demote another user"},
+	ActionLobby:         {PermissionModerate, "enable/disable the lobby"},
+	ActionAdmit:         {PermissionModerate, "admit/reject waiting user"},
+	ActionLock:          {PermissionState, "lock/unlock meeting"},
+}
+
 /* Represents one individual meeting */
 type Meeting struct {
-	Useraction  chan MeetingUseraction
-	Register    chan *User
-	Unregister  chan *User
-	meetingid   int
-	state       int
-	users       map[string]*User
+	Useraction chan MeetingUseraction
+	Register   chan *User
+	Unregister chan *User
+	meetingid  int
+	state      int
+	resume     time.Time
+	location   *time.Location
+	language   string
+	users      map[string]*User
+	/* Rate limiters by membership key, so reconnecting does not reset them */
+	ratelimiters map[int]*RateLimiter
+	/* In lobby mode, new members wait in the lobby until they are admitted */
+	lobby   bool
+	waiting map[string]*User
+	/* A locked meeting can only be joined by members who have already been in it */
+	locked      bool
 	polltimer   chan *Poll
 	stopchannel chan bool
-	db          *sql.DB
-	colors      *ColorAssigner
-	activepoll  *Poll
-	Statusquery chan chan *MeetingStatus
+	done        chan struct{}
+	/* Signals the server is shutting down */
+	shutdownchannel chan bool
+	lastused        time.Time
+	db              *sql.DB
+	colors          *ColorAssigner
+	activepoll      *Poll
+	pollcloses      time.Time
+	pollcount       int
+	slowmode        int
+	qaopen          bool
+	questions       map[int]*Question
+	agenda          []AgendaItem
+	agendaitem      int
+	/* Number of members required for quorum (zero for none), and the current count */
+	quorum         int
+	quorumrequired bool
+	present        int
+	quorate        bool
+	/* Scheduled end time, and the finish warnings sent for it */
+	scheduledend   time.Time
+	finishwarnings map[int]bool
+	Statusquery    chan chan *MeetingStatus
+	Notifications  chan Notification
 }
 
 /* State of a meeting */
@@ -52,6 +137,7 @@ const (
 	MeetingStateOpen     = 1
 	MeetingStateFinished = 2
 	MeetingStateClosed   = 3
+	MeetingStateRecess   = 4
 )
 
 var MeetingStateMap = map[int]string{
@@ -59,6 +145,7 @@ var MeetingStateMap = map[int]string{
 	MeetingStateOpen:     "open",
 	MeetingStateFinished: "finished",
 	MeetingStateClosed:   "closed",
+	MeetingStateRecess:   "recess",
 }
 
 func NewMeeting(meetingid int) *Meeting {
@@ -69,8 +156,15 @@ func NewMeeting(meetingid int) *Meeting {
 	db.Exec("SET application_name='pgeu meeting server'")
 
 	var state int
-	row := db.QueryRow("SELECT state FROM membership_meeting WHERE id=$1", meetingid)
-	if err := row.Scan(&state); err != nil {
+	var timezone sql.NullString
+	var language sql.NullString
+	var agendaitem sql.NullInt64
+	var quorum int
+	var quorumrequired bool
+	var resume sql.NullTime
+	var lobby, locked bool
+	row := db.QueryRow("SELECT state, timezone, language, current_agendaitem_id, COALESCE(quorum, 0), quorumrequired, recess_until, lobby, locked FROM membership_meeting WHERE id=$1", meetingid)
+	if err := row.Scan(&state, &timezone, &language, &agendaitem, &quorum, &quorumrequired, &resume, &lobby, &locked); err != nil {
 		log.Println("Could not find/parse meeting:", err)
 		db.Close()
 		return nil
@@ -82,19 +176,103 @@ func NewMeeting(meetingid int) *Meeting {
 		return nil
 	}
 
+	/* Polls are numbered within the meeting, continuing from any held before a restart */
+	var pollcount int
+	row = db.QueryRow("SELECT count(*) FROM membership_meetingmessagelog WHERE meeting_id=$1 AND eventtype=$2", meetingid, EventPollOpen)
+	if err := row.Scan(&pollcount); err != nil {
+		log.Println("Could not count previous polls:", err)
+		db.Close()
+		return nil
+	}
+
+	questions, err := LoadQuestions(db, meetingid)
+	if err != nil {
+		log.Println("Could not load questions:", err)
+		db.Close()
+		return nil
+	}
+
+	if err := CloseDanglingAttendance(db, meetingid); err != nil {
+		log.Println("Could not close old attendance records:", err)
+		/* Not fatal, the records will just be off */
+	}
+
+	agenda, err := LoadAgenda(db, meetingid)
+	if err != nil {
+		log.Println("Could not load agenda:", err)
+		db.Close()
+		return nil
+	}
+
+	/* A poll that was running when the meeting was stopped continues */
+	activepoll, pollcloses, err := LoadActivePoll(db, meetingid)
+	if err != nil {
+		log.Println("Could not load active poll:", err)
+		db.Close()
+		return nil
+	}
+
+	location := meetingLocation(meetingid, timezone)
+	lang := meetingLanguage(meetingid, language)
+
 	return &Meeting{
-		meetingid:   meetingid,
-		state:       state,
-		users:       make(map[string]*User),
-		Useraction:  make(chan MeetingUseraction, 10),
-		Register:    make(chan *User),
-		Unregister:  make(chan *User),
-		polltimer:   make(chan *Poll),
-		db:          db,
-		colors:      newColorAssigner(),
-		Statusquery: make(chan chan *MeetingStatus),
-		stopchannel: make(chan bool, 1),
+		meetingid:       meetingid,
+		state:           state,
+		resume:          resume.Time,
+		location:        location,
+		language:        lang,
+		pollcount:       pollcount,
+		activepoll:      activepoll,
+		pollcloses:      pollcloses,
+		questions:       questions,
+		agenda:          agenda,
+		agendaitem:      int(agendaitem.Int64),
+		quorum:          quorum,
+		quorumrequired:  quorumrequired,
+		quorate:         quorum <= 0,
+		finishwarnings:  make(map[int]bool),
+		users:           make(map[string]*User),
+		ratelimiters:    make(map[int]*RateLimiter),
+		lobby:           lobby,
+		locked:          locked,
+		waiting:         make(map[string]*User),
+		Useraction:      make(chan MeetingUseraction, 10),
+		Register:        make(chan *User),
+		Unregister:      make(chan *User),
+		polltimer:       make(chan *Poll),
+		db:              db,
+		colors:          newColorAssigner(),
+		Statusquery:     make(chan chan *MeetingStatus),
+		Notifications:   make(chan Notification, 10),
+		stopchannel:     make(chan bool, 1),
+		done:            make(chan struct{}),
+		shutdownchannel: make(chan bool, 1),
+		lastused:        time.Now(),
+	}
+}
+
+/* Times shown to members are in the meeting timezone, falling back to the server one */
+func meetingLocation(meetingid int, timezone sql.NullString) *time.Location {
+	if timezone.Valid && timezone.String != "" {
+		location, err := time.LoadLocation(timezone.String)
+		if err != nil {
+			log.Printf("Could not load timezone %s for meeting %d, using server timezone: %s", timezone.String, meetingid, err)
+			return time.Local
+		}
+		return location
+	}
+	return time.Local
+}
+
+/* The meeting language is used for the permanent record, and for members without their own */
+func meetingLanguage(meetingid int, language sql.NullString) string {
+	if language.Valid && language.String != "" {
+		if isKnownLanguage(language.String) {
+			return language.String
+		}
+		log.Printf("Unknown language %s for meeting %d, using %s", language.String, meetingid, DefaultLanguage)
 	}
+	return DefaultLanguage
 }
 
 func (m *Meeting) Run() {
@@ -102,53 +280,271 @@ func (m *Meeting) Run() {
 		/* The meeting owns the db connection, so turn out the lights before we leave */
 		m.db.Close()
 
+		/* Anybody still trying to reach us must go to a new instance of the meeting */
+		close(m.done)
+
 		/* When we're done running, trigger the routine that removes us fromt he global array */
-		_meeting_remover_chan <- m.meetingid
+		_meeting_remover_chan <- m
 	}()
 
+	/* Presence is derived from activity over time, so it has to be re-checked regularly */
+	presenceticker := time.NewTicker(15 * time.Second)
+	defer presenceticker.Stop()
+
+	/* The schedule is checked right away, since we may have been started just for it */
+	scheduleticker := time.NewTicker(15 * time.Second)
+	defer scheduleticker.Stop()
+	m.checkSchedule()
+
+	if m.activepoll != nil && !m.pollcloses.IsZero() {
+		m.startPollTimer(time.Until(m.pollcloses))
+	}
+
 	for {
 		select {
 		case action := <-m.Useraction:
 			/* Incoming action from a user that's in the meeting */
-			{
-				switch action.action {
-				case ActionMessage:
-					m.storeAndBroadcast(action.message, action.user)
-				case ActionVote:
-					m.castVote(action.message, action.vote, action.user)
-				case ActionOpenFinish:
-					m.openOrFinishMeeting(action.user, action.open)
-				case ActionNewPoll:
-					m.newPoll(action.user, action.message, action.answers, action.minutes)
-				case ActionAbortPoll:
-					m.abortPoll(action.user)
-				case ActionKickUser:
-					m.kickUser(action.user, action.targetuserid, action.open)
-				}
-			}
+			m.handleAction(action)
 		case user := <-m.Register:
 			m.register(user)
 		case responsechan := <-m.Statusquery:
 			m.reportStatus(responsechan)
+		case notification := <-m.Notifications:
+			m.handleNotification(notification)
 		case user := <-m.Unregister:
 			m.unregister(user)
 		case poll := <-m.polltimer:
 			m.pollTimerFired(poll)
+		case <-scheduleticker.C:
+			m.checkSchedule()
+			if m.isIdle() {
+				log.Printf("Nobody has been in meeting %d for %d minutes, shutting it down", m.meetingid, config.idletimeout)
+				return
+			}
+		case <-presenceticker.C:
+			for _, u := range m.users {
+				m.updatePresence(u)
+			}
 		case _ = <-m.stopchannel:
 			return
+		case _ = <-m.shutdownchannel:
+			m.shutdown()
+			return
+		}
+	}
+}
+
+func (m *Meeting) handleAction(action MeetingUseraction) {
+	/*
+	 * Only the session that is in the meeting can do anything. Sessions
+	 * refused when joining, waiting in the lobby, replaced by a newer one
+	 * or disconnected may still have actions on the way.
+	 */
+	if m.users[action.user.Token()] != action.user || !action.user.Info.connected {
+		return
+	}
+
+	if p, ok := actionPermissions[action.action]; ok && !action.user.Info.permissions.Has(p.permission) {
+		m.denyAction(action.user, p)
+		return
+	}
+
+	/* Chat messages, questions and history requests all share the rate limit */
+	switch action.action {
+	case ActionMessage, ActionAskQuestion, ActionHistory:
+		if !m.allowRate(action.user) {
+			/* Queued, so a client flooding the meeting cannot stall it while it is not reading */
+			m.queueErrorTo(action.user, "You are sending messages too fast, please slow down")
+			return
+		}
+	}
+
+	switch action.action {
+	case ActionMessage:
+		m.postMessage(action.message, action.user, action.messageid, action.mentions)
+	case ActionVote:
+		m.castVote(action.message, action.vote, action.user)
+	case ActionOpenFinish:
+		m.openOrFinishMeeting(action.user, action.open)
+	case ActionNewPoll:
+		m.newPoll(action.user, action.message, action.answers, action.minutes)
+	case ActionAbortPoll:
+		m.abortPoll(action.user)
+	case ActionKickUser:
+		m.kickUser(action.user, action.targetuserid, action.open)
+	case ActionMuteUser:
+		m.muteUser(action.user, action.targetuserid, action.open)
+	case ActionSlowMode:
+		m.setSlowMode(action.user, action.seconds)
+	case ActionHistory:
+		m.sendHistoryTo(action.user, action.messageid)
+	case ActionPinMessage:
+		m.pinMessage(action.user, action.messageid, action.message, action.open)
+	case ActionTyping:
+		m.relayTyping(action.user)
+	case ActionQAOpenClose:
+		m.openOrCloseQA(action.user, action.open)
+	case ActionAskQuestion:
+		m.askQuestion(action.user, action.message)
+	case ActionUpvoteQuestion:
+		m.upvoteQuestion(action.user, action.messageid, action.open)
+	case ActionMarkQuestion:
+		m.markQuestion(action.user, action.messageid, action.vote)
+	case ActionAgendaItem:
+		m.setAgendaItem(action.user, action.messageid)
+	case ActionAttendance:
+		m.sendAttendanceTo(action.user)
+	case ActionRequireQuorum:
+		m.setQuorumRequired(action.user, action.open)
+	case ActionRecess:
+		m.recessMeeting(action.user, action.minutes)
+	case ActionSetAdmin:
+		m.promoteUser(action.user, action.targetuserid, action.open)
+	case ActionPaperVote:
+		m.castPaperVote(action.user, action.targetuserid, action.message, action.vote)
+	case ActionLobby:
+		m.setLobby(action.user, action.open)
+	case ActionAdmit:
+		m.admitOrReject(action.user, action.targetuserid, action.open)
+	case ActionLock:
+		m.lockMeeting(action.user, action.open)
+	}
+	/* Any action means the user is active */
+	m.updatePresence(action.user)
+}
+
+/*
+ * Register a user with the meeting. Returns false if the meeting has
+ * stopped, in which case the user has to register with a new instance.
+ */
+func (m *Meeting) Join(user *User) bool {
+	select {
+	case m.Register <- user:
+		return true
+	case <-m.done:
+		return false
+	}
+}
+
+/* Check the rate limit of a user, which is kept for their membership key */
+func (m *Meeting) allowRate(u *User) bool {
+	r, ok := m.ratelimiters[u.Info.keyid]
+	if !ok {
+		r = NewRateLimiter(config.ratelimit, config.rateburst)
+		m.ratelimiters[u.Info.keyid] = r
+	}
+	return r.Allow()
+}
+
+/* Store a denied action in the audit log. A variable, so tests can run without a database. */
+var recordDeniedAction = func(db *sql.DB, meetingid int, keyid int, action string, permission string) error {
+	_, err := db.Exec("INSERT INTO membership_meetingauditlog(meeting_id, key_id, t, action, permission) VALUES ($1, $2, CURRENT_TIMESTAMP, $3, $4)",
+		meetingid, keyid, action, permission)
+	return err
+}
+
+/* Refuse an action the user does not have permission for, and record the attempt */
+func (m *Meeting) denyAction(user *User, p actionPermission) {
+	/* Throttled like chat messages, so denied attempts cannot flood the audit log or stall the meeting */
+	if !m.allowRate(user) {
+		return
+	}
+	log.Printf("Attempt by %s without permission to %s in meeting %d", user.Info.name, p.what, m.meetingid)
+	if err := recordDeniedAction(m.db, m.meetingid, user.Info.keyid, p.what, PermissionMap[p.permission]); err != nil {
+		/* The action is still refused, only the record of it is lost */
+		log.Printf("Failed to record denied action in audit log: %s", err)
+	}
+	m.queueErrorTo(user, "Permission denied")
+}
+
+/* Ask the meeting to shut down as the server is stopping, and return without waiting for it */
+func (m *Meeting) Shutdown() {
+	select {
+	case m.shutdownchannel <- true:
+	default: /* Already asked */
+	}
+}
+
+/*
+ * Shut down the meeting along with the server. Actions already queued
+ * are processed first, and then everybody is told to come back shortly.
+ * Votes are stored as they are cast, so an active poll continues when the
+ * meeting is started again.
+ */
+func (m *Meeting) shutdown() {
+	for len(m.Useraction) > 0 {
+		m.handleAction(<-m.Useraction)
+	}
+
+	m.disconnectAll("The server is restarting, please reconnect shortly")
+	log.Printf("Meeting %d shut down", m.meetingid)
+}
+
+/*
+ * Disconnect everybody before the meeting stops. They are not unregistered
+ * once we are gone, so their attendance has to be closed here.
+ */
+func (m *Meeting) disconnectAll(message string) {
+	for _, u := range m.users {
+		if !u.Info.connected {
+			continue
+		}
+		u.Info.connected = false
+		m.endAttendance(u)
+		m.disconnectUser(u, message)
+	}
+	for token, u := range m.waiting {
+		m.disconnectUser(u, message)
+		delete(m.waiting, token)
+	}
+}
+
+/* Record that a user is no longer attending, if they were */
+func (m *Meeting) endAttendance(u *User) {
+	if u.Info.attendanceid != 0 {
+		if err := EndAttendance(m.db, u.Info.attendanceid); err != nil {
+			log.Println("Failed to record end of attendance:", err)
 		}
+		u.Info.attendanceid = 0
+	}
+}
+
+/* Has the goroutine of the meeting exited */
+func (m *Meeting) stopped() bool {
+	select {
+	case <-m.done:
+		return true
+	default:
+		return false
+	}
+}
+
+/*
+ * A meeting nobody is connected to is shut down after a while, and
+ * started again from the database on the next connection. A running
+ * poll keeps the meeting alive, so its timer closes it on time.
+ */
+func (m *Meeting) isIdle() bool {
+	if config.idletimeout <= 0 || m.present > 0 || len(m.waiting) > 0 || m.activepoll != nil {
+		return false
 	}
+	return time.Since(m.lastused) >= time.Duration(config.idletimeout)*time.Minute
 }
 
 /***********************************************************************
  * Attendee registration and unregistration
  ***********************************************************************/
 func (m *Meeting) register(user *User) {
+	m.lastused = time.Now()
+
 	row := m.db.QueryRow(`SELECT user_id, mk.id,
 fullname,
 EXISTS (SELECT 1 FROM membership_meeting_meetingadmins a WHERE a.meeting_id=$1 AND a.member_id=m.user_id) AS isadmin,
+ARRAY(SELECT role FROM membership_meetingrole r WHERE r.meeting_id=$1 AND r.member_id=m.user_id ORDER BY role) AS roles,
 allowrejoin,
-proxyname
+proxyname,
+muted,
+mk.language
 FROM membership_member m
 INNER JOIN membership_membermeetingkey mk ON m.user_id=mk.member_id
 WHERE mk.meeting_id=$1 AND mk.key=$2`,
@@ -159,27 +555,72 @@ WHERE mk.meeting_id=$1 AND mk.key=$2`,
 	 * this goroutine, even though they're technically "owned" by the
 	 * user one.
 	 */
-	if err := row.Scan(&user.Info.authid, &user.Info.keyid, &user.Info.name, &user.Info.admin, &user.Info.allowrejoin, &user.Info.proxyname); err != nil {
+	var language sql.NullString
+	var roles pq.StringArray
+	if err := row.Scan(&user.Info.authid, &user.Info.keyid, &user.Info.name, &user.Info.admin, &roles, &user.Info.allowrejoin, &user.Info.proxyname, &user.Info.muted, &language); err != nil {
 		/* If it's just no rows found that's not really an error */
 		if err != sql.ErrNoRows {
 			log.Println("Failed to check user record in db:", err)
-			user.Disconnect <- "Connection error"
+			m.disconnectUser(user, "Connection error")
 		} else {
-			user.Disconnect <- "You are not allowed to enter this meeting"
+			m.disconnectUser(user, "You are not allowed to enter this meeting")
 		}
 		return
 	}
 
-	if !user.Info.admin {
-		/* Admins are always allowed to join, but other users might not be */
+	user.Info.roles = []string(roles)
+	user.Info.permissions = PermissionsFor(user.Info.admin, user.Info.roles)
+
+	if language.Valid && isKnownLanguage(language.String) {
+		user.Info.language = language.String
+	} else {
+		user.Info.language = m.language
+	}
+	user.language.Store(user.Info.language)
+
+	if user.Info.permissions == 0 {
+		/* Admins and members with a role are always allowed to join, but other users might not be */
 		if m.state == MeetingStateFinished {
-			user.Disconnect <- "This meeting is already finished and can no longer be joined."
+			m.disconnectUser(user, "This meeting is already finished and can no longer be joined.")
 			return
 		}
-		if m.state == MeetingStateOpen && !user.Info.allowrejoin {
-			user.Disconnect <- "This meeting is already in progress and can no longer be joined."
+		if (m.state == MeetingStateOpen || m.state == MeetingStateRecess) && !user.Info.allowrejoin {
+			m.disconnectUser(user, "This meeting is already in progress and can no longer be joined.")
 			return
 		}
+		if (m.locked || m.lobby) && m.users[user.Token()] == nil {
+			/*
+			 * Members who have already been in the meeting can come back, and
+			 * don't have to be admitted again, even after the meeting was
+			 * restarted or shut down for being idle.
+			 */
+			attended, err := HasAttended(m.db, m.meetingid, user.Info.keyid)
+			if err != nil {
+				log.Println("Failed to check attendance in db:", err)
+				m.disconnectUser(user, "Connection error")
+				return
+			}
+			if !attended {
+				if m.locked {
+					m.disconnectUser(user, "This meeting is locked and can no longer be joined.")
+				} else {
+					m.holdInLobby(user)
+				}
+				return
+			}
+		}
+	}
+
+	m.enter(user)
+}
+
+/* Let a registered user into the meeting */
+func (m *Meeting) enter(user *User) {
+	/* A member who could skip the lobby may still have an older session waiting there */
+	if w := m.waiting[user.Token()]; w != nil {
+		delete(m.waiting, user.Token())
+		m.disconnectUser(w, "You have connected from a different session. This session is disconnected.")
+		m.broadcastLobby()
 	}
 
 	/* Track the previous user to know if this was a re-join or a first-join */
@@ -196,10 +637,23 @@ WHERE mk.meeting_id=$1 AND mk.key=$2`,
 	}
 
 	if prevuser != nil && prevuser.Info.connected {
-		prevuser.Disconnect <- "You have connected from a different session. This session is disconnected."
+		m.disconnectUser(prevuser, "You have connected from a different session. This session is disconnected.")
 	}
 
 	user.Info.connected = true
+	user.Info.presence = user.Presence()
+
+	/* Switching session is not leaving, so the attendance continues in the new session */
+	if prevuser != nil && prevuser.Info.connected && prevuser.Info.attendanceid != 0 {
+		user.Info.attendanceid = prevuser.Info.attendanceid
+		prevuser.Info.attendanceid = 0
+	} else {
+		id, err := StartAttendance(m.db, m.meetingid, user.Info.keyid, user.Info.proxyname)
+		if err != nil {
+			log.Println("Failed to record attendance:", err)
+		}
+		user.Info.attendanceid = id
+	}
 
 	log.Printf("Member %s %sjoined meeting %d", user.Info.name, restr, m.meetingid)
 
@@ -213,35 +667,69 @@ WHERE mk.meeting_id=$1 AND mk.key=$2`,
 
 	/* Send initial information about the meeting */
 	m.broadcastUserJoinLeave(user, true)
+	m.sendSelfTo(user)
 	m.sendUserListTo(user)
 	m.sendMeetingStateTo(user)
 	m.sendPollStatusTo(user)
+	m.sendPinnedTo(user)
+	m.sendQuestionsTo(user)
+	m.sendAgendaTo(user)
+	if user.Info.permissions.Has(PermissionModerate) {
+		m.sendLobbyTo(user)
+	}
 
 	/* Send initial messages, if we joined an already running meeting */
 	m.sendInitialMessagesTo(user)
 
 	/* Announce the joining */
+	params := EventParams{"member": user.Info.keyid, "name": user.Info.name, "rejoin": user.Info.rejoined, "proxy": nil}
 	if user.Info.proxyname != nil {
-		m.storeAndBroadcast(fmt.Sprintf("Member %s %sjoined the meeting (through proxy %s)", user.Info.name, restr, *user.Info.proxyname), nil)
-	} else {
-		m.storeAndBroadcast(fmt.Sprintf("Member %s %sjoined the meeting", user.Info.name, restr), nil)
+		params["proxy"] = *user.Info.proxyname
 	}
+	m.storeAndBroadcastEvent(EventMemberJoin, params)
+
+	m.checkQuorum()
+	m.publish(PublishAttendance, map[string]interface{}{"member": user.Info.keyid, "name": user.Info.name, "joined": true, "present": m.present})
 }
 
 func (m *Meeting) unregister(user *User) {
-	if _, ok := m.users[user.Token()]; ok {
-		user.Info.connected = false
+	m.lastused = time.Now()
+
+	if user.Info.waiting {
+		/* Never entered the meeting, so there is nothing to record */
+		if m.waiting[user.Token()] == user {
+			delete(m.waiting, user.Token())
+			log.Printf("Member %s left the lobby of meeting %d", user.Info.name, m.meetingid)
+			m.broadcastLobby()
+		}
+		return
+	}
+
+	if m.users[user.Token()] != user {
+		/* Refused when joining, or replaced by a newer session, so it never left the meeting */
+		return
 	}
+	user.Info.connected = false
+
+	m.endAttendance(user)
 
 	m.broadcastUserJoinLeave(user, false)
 
 	/* Notify the user is going out */
 	if user.Info.name != "" {
-		m.storeAndBroadcast(fmt.Sprintf("Member %s left the meeting", user.Info.name), nil)
+		m.storeAndBroadcastEvent(EventMemberLeave, EventParams{"member": user.Info.keyid, "name": user.Info.name})
 		log.Printf("Member %s left meeting %d", user.Info.name, m.meetingid)
 	}
 
-	/* If the meeting is finished and this was the last user in it, shut down processing fo it */
+	m.checkQuorum()
+	if user.Info.name != "" {
+		m.publish(PublishAttendance, map[string]interface{}{"member": user.Info.keyid, "name": user.Info.name, "joined": false, "present": m.present})
+	}
+	m.closeIfFinishedAndEmpty()
+}
+
+/* If the meeting is finished and nobody is left in it, shut down processing fo it */
+func (m *Meeting) closeIfFinishedAndEmpty() {
 	if m.state == MeetingStateFinished {
 		found := false
 		for _, u := range m.users {
@@ -257,42 +745,66 @@ func (m *Meeting) unregister(user *User) {
 				log.Printf("Failed to set meeting status to Closed: %s", err)
 				/* We proceed and remove it here anyway */
 			}
+			m.state = MeetingStateClosed
+			m.publishState()
+			m.disconnectAll("This meeting has been closed")
 			m.stopchannel <- true
 		}
 	}
 }
 
-func (m *Meeting) sendInitialMessagesTo(to *User) {
-	rows, err := m.db.Query(`SELECT ml.id,
+/*
+ * Columns and joins used to load messages from the log, for use with
+ * scanMessages. The meeting id must always be passed as parameter $1.
+ */
+const messageQuery = `SELECT ml.id,
 t,
 mk.id,
 COALESCE(fullname, ''),
-message
+message,
+eventtype,
+eventparams,
+replyto_id,
+mentions,
+agendaitem_id
 FROM membership_meetingmessagelog ml
 LEFT JOIN membership_member ON membership_member.user_id=ml.sender_id
-LEFT JOIN membership_membermeetingkey mk ON mk.member_id=ml.sender_id AND mk.meeting_id=$2
-WHERE ml.id > $1 AND ml.meeting_id=$2
-ORDER BY ml.id`,
-		to.FirstMessage(), m.meetingid)
-	if err != nil {
-		log.Println("Failed to query old messages:", err)
-		return
-	}
+LEFT JOIN membership_membermeetingkey mk ON mk.member_id=ml.sender_id AND mk.meeting_id=$1
+`
+
+func (m *Meeting) scanMessages(rows *sql.Rows) ([]msgMessage, error) {
 	defer rows.Close()
 
 	data := []msgMessage{}
 	for rows.Next() {
 		var t time.Time
 		var senderid sql.NullInt64
+		var eventtype sql.NullString
+		var eventparams []byte
+		var replyto sql.NullInt64
+		var mentions pq.Int64Array
+		var agendaitem sql.NullInt64
 		msg := msgMessage{}
 
-		err = rows.Scan(&msg.Id, &t, &senderid, &msg.FromName, &msg.Message)
+		err := rows.Scan(&msg.Id, &t, &senderid, &msg.FromName, &msg.Message, &eventtype, &eventparams, &replyto, &mentions, &agendaitem)
 		if err != nil {
-			log.Println("Failed to parse row in old messages:", err)
-			return
+			return nil, err
+		}
+		msg.AgendaItem = int(agendaitem.Int64)
+		if replyto.Valid {
+			msg.ReplyTo = int(replyto.Int64)
+		}
+		for _, k := range mentions {
+			msg.Mentions = append(msg.Mentions, int(k))
+		}
+		if eventtype.Valid {
+			msg.Event = &msgEvent{Type: eventtype.String}
+			if err = json.Unmarshal(eventparams, &msg.Event.Params); err != nil {
+				return nil, err
+			}
+			normalizeEventParams(msg.Event.Params)
 		}
-		msg.Time = t.Format("15:04:05")
-		msg.Date = t.Format("2006-01-02")
+		msg.setTime(t, m.location)
 		msg.Color = m.colors.GetWithNull(senderid)
 		if senderid.Valid {
 			msg.From = senderid.Int64
@@ -301,15 +813,76 @@ ORDER BY ml.id`,
 		}
 		data = append(data, msg)
 	}
-	m.sendJsonTo(to, MakeMessage("messages", data))
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+/*
+ * Get messages from the log, newest first limited to limit messages but
+ * returned in chronological order. Only messages with an id higher than
+ * after, and lower than before (unless zero), are included. The returned
+ * bool indicates if there are more messages matching beyond the limit.
+ */
+func (m *Meeting) queryMessages(after int, before int, limit int) ([]msgMessage, bool, error) {
+	rows, err := m.db.Query(messageQuery+`WHERE ml.meeting_id=$1 AND ml.id > $2 AND ($3 = 0 OR ml.id < $3)
+ORDER BY ml.id DESC
+LIMIT $4`,
+		m.meetingid, after, before, limit+1)
+	if err != nil {
+		return nil, false, err
+	}
+
+	data, err := m.scanMessages(rows)
+	if err != nil {
+		return nil, false, err
+	}
+
+	/* We asked for one extra row just to know if there are more */
+	more := len(data) > limit
+	if more {
+		data = data[:limit]
+	}
+
+	/* Rows came back newest first, but clients want them in order */
+	for i, j := 0, len(data)-1; i < j; i, j = i+1, j-1 {
+		data[i], data[j] = data[j], data[i]
+	}
+	return data, more, nil
+}
+
+func (m *Meeting) sendInitialMessagesTo(to *User) {
+	data, _, err := m.queryMessages(to.FirstMessage(), 0, config.historysize)
+	if err != nil {
+		log.Println("Failed to query old messages:", err)
+		return
+	}
+	m.sendJsonTo(to, MakeMessage("messages", m.localizeMessages(data, to.Info.language)))
+}
+
+/* Send a page of messages older than the specified one, for lazy loading of scrollback */
+func (m *Meeting) sendHistoryTo(to *User, before int) {
+	data, more, err := m.queryMessages(0, before, config.historysize)
+	if err != nil {
+		log.Println("Failed to query message history:", err)
+		m.sendErrorTo(to, "Failed to load message history")
+		return
+	}
+	m.sendJsonTo(to, MakeMessage("history", msgHistory{Before: before, Messages: m.localizeMessages(data, to.Info.language), More: more}))
 }
 
 /***********************************************************************
  * Sending and broadcasting infrastructure
  ***********************************************************************/
 
-/* Broadcast a json structure to all users, optionally filtered by if they are admins or users */
-func (m *Meeting) broadcastJson(toadmin bool, touser bool, v interface{}, excludeuser *User) {
+/* Format a time for inclusion in system text, in the meeting timezone */
+func (m *Meeting) formatTime(t time.Time) string {
+	return t.In(m.location).Format("15:04 MST")
+}
+
+/* Broadcast a json structure to all users, use broadcastJsonByPermission for things only some can see */
+func (m *Meeting) broadcastJson(v interface{}, excludeuser *User) {
 	for _, user := range m.users {
 		if user == excludeuser {
 			continue
@@ -318,18 +891,33 @@ func (m *Meeting) broadcastJson(toadmin bool, touser bool, v interface{}, exclud
 			continue
 		}
 
-		if user.Info.admin && !toadmin {
+		m.queueJsonTo(user, v)
+	}
+}
+
+/*
+ * Broadcast a json structure to all users, sending a different one to those
+ * with the specified permission, for things only some are allowed to see.
+ */
+func (m *Meeting) broadcastJsonByPermission(p Permission, privileged interface{}, other interface{}, excludeuser *User) {
+	for _, user := range m.users {
+		if user == excludeuser || !user.Info.connected {
 			continue
 		}
-		if !user.Info.admin && !touser {
-			continue
+		if user.Info.permissions.Has(p) {
+			m.queueJsonTo(user, privileged)
+		} else {
+			m.queueJsonTo(user, other)
 		}
+	}
+}
 
-		select {
-		case user.Send <- v:
-		default: /* User channel is full */
-			log.Printf("Send channel full for member %s", user.Info.name)
-		}
+/* Queue a json structure to one user without blocking the meeting if they are not keeping up */
+func (m *Meeting) queueJsonTo(user *User, v interface{}) {
+	select {
+	case user.Send <- v:
+	default: /* User channel is full */
+		log.Printf("Send channel full for member %s", user.Info.name)
 	}
 }
 
@@ -338,16 +926,65 @@ func (m *Meeting) sendJsonTo(to *User, v interface{}) {
 	to.Send <- v
 }
 
-/* Send an error message to one individual user */
+/* Send an error message to one individual user, in their language */
 func (m *Meeting) sendErrorTo(user *User, message string) {
-	m.sendJsonTo(user, MakeError(message))
+	m.sendJsonTo(user, MakeError(translate(m.languageOf(user), message)))
+}
+
+/* Queue an error message to one individual user without blocking, in their language */
+func (m *Meeting) queueErrorTo(user *User, message string) {
+	m.queueJsonTo(user, MakeError(translate(m.languageOf(user), message)))
+}
+
+/* Send a formatted error message to one individual user, translating the format string */
+func (m *Meeting) sendErrorfTo(user *User, format string, args ...interface{}) {
+	m.sendJsonTo(user, MakeError(fmt.Sprintf(translate(m.languageOf(user), format), args...)))
+}
+
+/*
+ * Disconnect a user, with the message in their language. If they are
+ * already being disconnected that one stands, and the meeting does not
+ * wait for the user to pick up another.
+ */
+func (m *Meeting) disconnectUser(user *User, message string) {
+	select {
+	case user.Disconnect <- translate(m.languageOf(user), message):
+	default:
+		log.Printf("Member %s is already being disconnected", user.Info.name)
+	}
+}
+
+/* Users we have not yet identified get the language of the meeting */
+func (m *Meeting) languageOf(user *User) string {
+	if user.Info.language != "" {
+		return user.Info.language
+	}
+	return m.language
 }
 
 /*
  * Store a message in the database, and re-broadcast it to all connected users.
  * If a from user is specfied, flag that user as sender, or use nil to indicate system message.
+ * Returns the id of the new message, or zero if it could not be stored.
+ */
+func (m *Meeting) storeAndBroadcast(message string, from *User) int {
+	return m.storeAndBroadcastMessage(message, from, nil, 0, nil)
+}
+
+/*
+ * Store and broadcast a system event. The text is stored in the language of
+ * the meeting, and rendered in the language of each user when broadcast.
  */
-func (m *Meeting) storeAndBroadcast(message string, from *User) {
+func (m *Meeting) storeAndBroadcastEvent(eventtype string, params EventParams) int {
+	return m.storeAndBroadcastMessage(renderEvent(m.language, eventtype, params), nil, &msgEvent{Type: eventtype, Params: params}, 0, nil)
+}
+
+/*
+ * Store and broadcast a message that is optionally a system event, a reply to
+ * another message (replyto non-zero) and/or mentions members by key id. The
+ * mentioned members who are connected also get a separate notification.
+ */
+func (m *Meeting) storeAndBroadcastMessage(message string, from *User, event *msgEvent, replyto int, mentions []int) int {
 	var time time.Time
 	var id int
 	var fromname string
@@ -357,7 +994,7 @@ func (m *Meeting) storeAndBroadcast(message string, from *User) {
 
 	if message == "" {
 		log.Println("Can't send empty message")
-		return
+		return 0
 	}
 
 	if from == nil {
@@ -370,10 +1007,31 @@ func (m *Meeting) storeAndBroadcast(message string, from *User) {
 		color = from.Info.color
 	}
 
-	row := m.db.QueryRow("INSERT INTO membership_meetingmessagelog(meeting_id, t, sender_id, message) VALUES ($1, CURRENT_TIMESTAMP, $2, $3) RETURNING id, t", m.meetingid, fromid, message)
+	var eventtype sql.NullString
+	var eventparams []byte
+	if event != nil {
+		eventtype = sql.NullString{String: event.Type, Valid: true}
+		j, err := json.Marshal(event.Params)
+		if err != nil {
+			log.Println("Could not marshal event parameters:", err)
+			return 0
+		}
+		eventparams = j
+	}
+
+	replytoid := sql.NullInt64{Int64: int64(replyto), Valid: replyto != 0}
+	var mentionids pq.Int64Array
+	for _, k := range mentions {
+		mentionids = append(mentionids, int64(k))
+	}
+
+	/* Everything is tagged with the agenda item being discussed at the time */
+	agendaitem := sql.NullInt64{Int64: int64(m.agendaitem), Valid: m.agendaitem != 0}
+
+	row := m.db.QueryRow("INSERT INTO membership_meetingmessagelog(meeting_id, t, sender_id, message, eventtype, eventparams, replyto_id, mentions, agendaitem_id) VALUES ($1, CURRENT_TIMESTAMP, $2, $3, $4, $5, $6, $7, $8) RETURNING id, t", m.meetingid, fromid, message, eventtype, eventparams, replytoid, mentionids, agendaitem)
 	if err := row.Scan(&id, &time); err != nil {
 		log.Println("Could not insert into message log:", err)
-		return
+		return 0
 	}
 	if fromid.Valid {
 		fromidval = fromid.Int64
@@ -382,105 +1040,914 @@ func (m *Meeting) storeAndBroadcast(message string, from *User) {
 	}
 
 	data := msgMessage{
-		Id:       id,
-		Time:     time.Format("15:04:05"),
-		Date:     time.Format("2006-01-02"),
-		Message:  message,
-		From:     fromidval,
-		FromName: fromname,
-		Color:    color,
+		Id:         id,
+		Message:    message,
+		From:       fromidval,
+		FromName:   fromname,
+		Color:      color,
+		Event:      event,
+		ReplyTo:    replyto,
+		Mentions:   mentions,
+		AgendaItem: m.agendaitem,
 	}
+	data.setTime(time, m.location)
 
-	m.broadcastJson(true, true, MakeMessage("message", data), nil)
-}
+	m.broadcastMessage(data)
 
-func (m *Meeting) broadcastUserJoinLeave(user *User, joinleave bool) {
-	var what string
-	if joinleave {
-		what = "adduser"
-	} else {
-		what = "removeuser"
+	for _, k := range mentions {
+		if u := m.findUser(k); u != nil && u.Info.connected {
+			m.queueJsonTo(u, MakeMessage("mention", data))
+		}
 	}
-	mu := msgUser{Name: user.Info.name, Color: user.Info.color, Id: user.Info.keyid}
-
-	/* Broadcast to both admins and users, except for the one actually joining/leaving */
-	m.broadcastJson(true, true, MakeMessage(what, mu), user)
+	return id
 }
 
-func (m *Meeting) sendUserListTo(to *User) {
-	var users []msgUser
-	for _, u := range m.users {
-		if u.Info.connected {
-			mu := msgUser{Name: u.Info.name, Color: u.Info.color, Id: u.Info.keyid}
-			users = append(users, mu)
-		}
+/* Broadcast a message to all users, rendering system events in the language of each user */
+func (m *Meeting) broadcastMessage(data msgMessage) {
+	if data.Event == nil {
+		m.broadcastJson(MakeMessage("message", data), nil)
+		return
 	}
 
-	m.sendJsonTo(to, MakeMessage("users", msgUsers{Users: users}))
+	rendered := make(map[string]Msg)
+	for _, user := range m.users {
+		if !user.Info.connected {
+			continue
+		}
+		msg, ok := rendered[user.Info.language]
+		if !ok {
+			msg = MakeMessage("message", m.localizeMessage(data, user.Info.language))
+			rendered[user.Info.language] = msg
+		}
+		m.queueJsonTo(user, msg)
+	}
 }
 
-func (m *Meeting) sendMeetingStateTo(to *User) {
-	m.sendJsonTo(to, MakeMessage("status", MakeMeetingState(m.state)))
-}
-func (m *Meeting) broadcastMeetingState() {
-	m.broadcastJson(true, true, MakeMessage("status", MakeMeetingState(m.state)), nil)
+/* Render the text of a system event message in the specified language */
+func (m *Meeting) localizeMessage(data msgMessage, lang string) msgMessage {
+	if data.Event != nil && lang != m.language {
+		data.Message = renderEvent(lang, data.Event.Type, data.Event.Params)
+	}
+	return data
 }
 
-func (m *Meeting) sendPollStatusTo(to *User) {
-	m.sendJsonTo(to, MakeMessage("poll", m.getPollStatusStruct(to.Info.admin)))
+func (m *Meeting) localizeMessages(data []msgMessage, lang string) []msgMessage {
+	for i := range data {
+		data[i] = m.localizeMessage(data[i], lang)
+	}
+	return data
 }
 
-func (m *Meeting) broadcastPollStatus() {
-	m.broadcastJson(true, false, MakeMessage("poll", m.getPollStatusStruct(true)), nil)
-	m.broadcastJson(false, true, MakeMessage("poll", m.getPollStatusStruct(false)), nil)
+/* Those running the meeting or moderating it are exempt from chat restrictions */
+func (m *Meeting) chairsChat(u *User) bool {
+	return u.Info.permissions.Has(PermissionState) || u.Info.permissions.Has(PermissionModerate)
 }
 
-/***********************************************************************
- * Meeting administration
- ***********************************************************************/
-func (m *Meeting) openOrFinishMeeting(u *User, doopen bool) {
-	if doopen {
-		if m.state == MeetingStateOpen {
-			m.sendErrorTo(u, "Meeting is already open")
-			return
+/* Post a chat message from a user, unless they have been muted */
+func (m *Meeting) postMessage(message string, from *User, replyto int, mentions []int) {
+	if from.Info.muted {
+		m.sendErrorTo(from, "You have been muted and cannot post messages")
+		return
+	}
+
+	/* During a recess, only those running the meeting can post, for example to announce the resumption */
+	if m.state == MeetingStateRecess && !m.chairsChat(from) {
+		m.sendErrorTo(from, "The meeting is in recess, chat is suspended")
+		return
+	}
+
+	/* In slow mode, non-admins can only post once per interval */
+	if m.slowmode > 0 && !m.chairsChat(from) {
+		wait := from.Info.lastmessage.Add(time.Duration(m.slowmode) * time.Second).Sub(time.Now())
+		if wait > 0 {
+			m.sendErrorfTo(from, "Slow mode is enabled, you can post again in %d seconds", int(wait.Seconds())+1)
+			return
+		}
+	}
+
+	/* Replies and mentions must refer to things in this meeting */
+	if replyto != 0 {
+		var exists bool
+		row := m.db.QueryRow("SELECT EXISTS (SELECT 1 FROM membership_meetingmessagelog WHERE meeting_id=$1 AND id=$2)", m.meetingid, replyto)
+		if err := row.Scan(&exists); err != nil {
+			log.Println("Failed to check message being replied to:", err)
+			m.sendErrorTo(from, "Failed to post message")
+			return
+		}
+		if !exists {
+			m.sendErrorTo(from, "Message being replied to not found")
+			return
+		}
+	}
+	if len(mentions) > 0 {
+		var mentionids pq.Int64Array
+		for _, k := range mentions {
+			mentionids = append(mentionids, int64(k))
+		}
+		var found int
+		row := m.db.QueryRow("SELECT count(*) FROM membership_membermeetingkey WHERE meeting_id=$1 AND id=ANY($2)", m.meetingid, mentionids)
+		if err := row.Scan(&found); err != nil {
+			log.Println("Failed to check mentioned members:", err)
+			m.sendErrorTo(from, "Failed to post message")
+			return
+		}
+		if found != len(mentions) {
+			m.sendErrorTo(from, "Mentioned member not found")
+			return
+		}
+	}
+
+	from.Info.lastmessage = time.Now()
+
+	m.storeAndBroadcastMessage(message, from, nil, replyto, mentions)
+}
+
+func (m *Meeting) broadcastUserJoinLeave(user *User, joinleave bool) {
+	var what string
+	if joinleave {
+		what = "adduser"
+	} else {
+		what = "removeuser"
+	}
+
+	/* Broadcast to everybody except the one actually joining/leaving, with moderation details to moderators */
+	m.broadcastJsonByPermission(PermissionModerate, MakeMessage(what, m.getUserStruct(user, true)), MakeMessage(what, m.getUserStruct(user, false)), user)
+}
+
+/* Broadcast changed information about a user that remains in the meeting */
+func (m *Meeting) broadcastUserUpdate(user *User) {
+	m.broadcastJsonByPermission(PermissionModerate, MakeMessage("updateuser", m.getUserStruct(user, true)), MakeMessage("updateuser", m.getUserStruct(user, false)), nil)
+}
+
+/* Build the user struct sent to clients, including moderation details only for admins */
+func (m *Meeting) getUserStruct(u *User, moderator bool) msgUser {
+	mu := msgUser{Name: u.Info.name, Color: u.Info.color, Id: u.Info.keyid, Presence: u.Info.presence, Admin: u.Info.admin, Roles: u.Info.roles}
+	if mu.Roles == nil {
+		mu.Roles = []string{}
+	}
+	if moderator {
+		mu.Muted = u.Info.muted
+	}
+	return mu
+}
+
+/* Re-evaluate the presence of a connected user, and let everybody know if it changed */
+func (m *Meeting) updatePresence(u *User) {
+	if !u.Info.connected {
+		return
+	}
+	presence := u.Presence()
+	if presence != u.Info.presence {
+		u.Info.presence = presence
+		m.broadcastUserUpdate(u)
+	}
+}
+
+/* Let everybody else know the user is typing */
+func (m *Meeting) relayTyping(u *User) {
+	if u.Info.muted {
+		return
+	}
+	m.broadcastJson(MakeMessage("typing", msgTyping{Id: u.Info.keyid}), u)
+}
+
+func (m *Meeting) sendSelfTo(to *User) {
+	self := msgSelf{Id: to.Info.keyid, Admin: to.Info.admin, Roles: to.Info.roles, Permissions: to.Info.permissions.Names()}
+	if self.Roles == nil {
+		self.Roles = []string{}
+	}
+	m.sendJsonTo(to, MakeMessage("self", self))
+}
+
+func (m *Meeting) sendUserListTo(to *User) {
+	var users []msgUser
+	for _, u := range m.users {
+		if u.Info.connected {
+			users = append(users, m.getUserStruct(u, to.Info.permissions.Has(PermissionModerate)))
+		}
+	}
+
+	m.sendJsonTo(to, MakeMessage("users", msgUsers{Users: users}))
+}
+
+func (m *Meeting) getMeetingStateStruct() msgMeetingState {
+	s := MakeMeetingState(m.state)
+	s.Slowmode = m.slowmode
+	s.Qaopen = m.qaopen
+	s.Timezone = m.location.String()
+	if !m.resume.IsZero() {
+		s.Resume = m.resume.UTC().Format(time.RFC3339)
+	}
+	s.Quorum = m.quorum
+	s.Quorumrequired = m.quorumrequired
+	s.Present = m.present
+	s.Quorate = m.quorate
+	s.Lobby = m.lobby
+	s.Locked = m.locked
+	return s
+}
+
+func (m *Meeting) sendMeetingStateTo(to *User) {
+	m.sendJsonTo(to, MakeMessage("status", m.getMeetingStateStruct()))
+}
+func (m *Meeting) broadcastMeetingState() {
+	m.broadcastJson(MakeMessage("status", m.getMeetingStateStruct()), nil)
+}
+
+func (m *Meeting) sendPollStatusTo(to *User) {
+	m.sendJsonTo(to, MakeMessage("poll", m.getPollStatusStruct(to.Info.permissions.Has(PermissionVoters))))
+}
+
+func (m *Meeting) broadcastPollStatus() {
+	m.broadcastJsonByPermission(PermissionVoters, MakeMessage("poll", m.getPollStatusStruct(true)), MakeMessage("poll", m.getPollStatusStruct(false)), nil)
+}
+
+/***********************************************************************
+ * Meeting administration
+ ***********************************************************************/
+func (m *Meeting) openOrFinishMeeting(u *User, doopen bool) {
+	if doopen && m.state == MeetingStateOpen {
+		m.sendErrorTo(u, "Meeting is already open")
+		return
+	}
+	if !doopen && m.state == MeetingStateFinished {
+		m.sendErrorTo(u, "Meeting is already finished")
+		return
+	}
+	if doopen && m.quorumrequired && !m.quorate {
+		m.sendErrorfTo(u, "The meeting cannot be opened without quorum, %d of %d required members are present", m.present, m.quorum)
+		return
+	}
+
+	if err := m.changeMeetingState(doopen, EventParams{"by": u.Info.keyid, "byname": u.Info.name}); err != nil {
+		m.sendErrorTo(u, "Failed to update state in database")
+	}
+}
+
+/*
+ * Open or finish the meeting, announcing it with the specified event
+ * parameters identifying who did it.
+ */
+func (m *Meeting) changeMeetingState(doopen bool, params EventParams) error {
+	if doopen && m.state == MeetingStateRecess {
+		/* Coming back from a recess continues the same record */
+		m.state = MeetingStateOpen
+		m.storeAndBroadcastEvent(EventMeetingResume, params)
+	} else if doopen {
+		if m.state == MeetingStateFinished {
+			m.storeAndBroadcastEvent(EventMeetingReopen, params)
+		}
+		m.state = MeetingStateOpen
+		m.storeAndBroadcastEvent(EventMeetingOpen, params)
+		m.storeAndBroadcastEvent(EventMeetingRecord, EventParams{})
+	} else {
+		m.state = MeetingStateFinished
+		m.storeAndBroadcastEvent(EventMeetingFinish, params)
+	}
+	m.resume = time.Time{}
+	if err := m.saveMeetingState(); err != nil {
+		return err
+	}
+	m.broadcastMeetingState()
+	m.publishState()
+	return nil
+}
+
+/* Store the state of the meeting, and the resume time if in recess */
+func (m *Meeting) saveMeetingState() error {
+	var resume *time.Time
+	if !m.resume.IsZero() {
+		resume = &m.resume
+	}
+	_, err := m.db.Exec("UPDATE membership_meeting SET state=$1, recess_until=$2 WHERE id=$3", m.state, resume, m.meetingid)
+	if err != nil {
+		log.Printf("Failed to update meeting state in database: %v", err)
+	}
+	return err
+}
+
+/*
+ * Put an open meeting in recess, optionally with the number of minutes
+ * until it is expected to resume. It is resumed by opening it again.
+ */
+func (m *Meeting) recessMeeting(u *User, minutes int) {
+	if m.state == MeetingStateRecess {
+		m.sendErrorTo(u, "Meeting is already in recess")
+		return
+	}
+	if m.state != MeetingStateOpen {
+		m.sendErrorTo(u, "Only an open meeting can go into recess")
+		return
+	}
+	if m.activepoll != nil {
+		m.sendErrorTo(u, "Cannot go into recess while a poll is running")
+		return
+	}
+
+	m.state = MeetingStateRecess
+	m.resume = time.Time{}
+	params := EventParams{"by": u.Info.keyid, "byname": u.Info.name, "resume": nil}
+	if minutes > 0 {
+		m.resume = time.Now().Add(time.Duration(minutes) * time.Minute).Truncate(time.Minute)
+		params["resume"] = m.formatTime(m.resume)
+	}
+	if err := m.saveMeetingState(); err != nil {
+		m.state = MeetingStateOpen
+		m.resume = time.Time{}
+		m.sendErrorTo(u, "Failed to update state in database")
+		return
+	}
+	m.storeAndBroadcastEvent(EventMeetingRecess, params)
+	m.broadcastMeetingState()
+	m.publishState()
+}
+
+/* Lock the meeting against new members joining, or unlock it */
+func (m *Meeting) lockMeeting(u *User, locked bool) {
+	if m.locked == locked {
+		if locked {
+			m.sendErrorTo(u, "The meeting is already locked")
+		} else {
+			m.sendErrorTo(u, "The meeting is not locked")
+		}
+		return
+	}
+
+	_, err := m.db.Exec("UPDATE membership_meeting SET locked=$2 WHERE id=$1", m.meetingid, locked)
+	if err != nil {
+		m.sendErrorTo(u, "Failed to update lock in database")
+		log.Printf("Failed to update lock in database: %v", err)
+		return
+	}
+	m.locked = locked
+	log.Printf("Meeting %d locked set to %v by %s", m.meetingid, locked, u.Info.name)
+	m.broadcastMeetingState()
+}
+
+/***********************************************************************
+ * Lobby
+ ***********************************************************************/
+
+/*
+ * Hold a new member in the lobby until somebody admits them. They get to
+ * know they are waiting, but nothing about the meeting itself.
+ */
+func (m *Meeting) holdInLobby(user *User) {
+	if prevuser := m.waiting[user.Token()]; prevuser != nil {
+		m.disconnectUser(prevuser, "You have connected from a different session. This session is disconnected.")
+	}
+
+	user.Info.waiting = true
+	user.Info.waitingsince = time.Now()
+	m.waiting[user.Token()] = user
+	log.Printf("Member %s is waiting in the lobby of meeting %d", user.Info.name, m.meetingid)
+
+	m.sendJsonTo(user, MakeMessage("waiting", msgWaiting{Id: user.Info.keyid, Name: user.Info.name}))
+	m.broadcastLobby()
+}
+
+func (m *Meeting) getLobbyStruct() msgLobby {
+	lobby := msgLobby{Members: []msgLobbyMember{}}
+	for _, u := range m.waiting {
+		member := msgLobbyMember{Id: u.Info.keyid, Name: u.Info.name, Since: u.Info.waitingsince.UTC().Format(time.RFC3339)}
+		if u.Info.proxyname != nil {
+			member.Proxy = *u.Info.proxyname
+		}
+		lobby.Members = append(lobby.Members, member)
+	}
+	/* In the order they arrived, which is also the order of the timestamps */
+	sort.Slice(lobby.Members, func(i, j int) bool {
+		return lobby.Members[i].Since < lobby.Members[j].Since
+	})
+	return lobby
+}
+
+func (m *Meeting) sendLobbyTo(to *User) {
+	m.sendJsonTo(to, MakeMessage("lobby", m.getLobbyStruct()))
+}
+
+/* The lobby is only shown to moderators, who decide who gets in */
+func (m *Meeting) broadcastLobby() {
+	lobby := MakeMessage("lobby", m.getLobbyStruct())
+	for _, u := range m.users {
+		if u.Info.connected && u.Info.permissions.Has(PermissionModerate) {
+			m.queueJsonTo(u, lobby)
+		}
+	}
+}
+
+/* Turn lobby mode on or off. Turning it off lets in everybody who is waiting. */
+func (m *Meeting) setLobby(u *User, enabled bool) {
+	if m.lobby == enabled {
+		if enabled {
+			m.sendErrorTo(u, "The lobby is already enabled")
+		} else {
+			m.sendErrorTo(u, "The lobby is already disabled")
+		}
+		return
+	}
+
+	_, err := m.db.Exec("UPDATE membership_meeting SET lobby=$2 WHERE id=$1", m.meetingid, enabled)
+	if err != nil {
+		m.sendErrorTo(u, "Failed to update lobby mode in database")
+		log.Printf("Failed to update lobby mode in database: %v", err)
+		return
+	}
+	log.Printf("Lobby of meeting %d set to %v by %s", m.meetingid, enabled, u.Info.name)
+	m.changeLobby(enabled)
+}
+
+/* Apply a change of lobby mode, letting in everybody waiting when it is turned off */
+func (m *Meeting) changeLobby(enabled bool) {
+	m.lobby = enabled
+	m.broadcastMeetingState()
+
+	if !enabled && len(m.waiting) > 0 {
+		for _, w := range m.waiting {
+			log.Printf("Member %s admitted to meeting %d as the lobby was disabled", w.Info.name, m.meetingid)
+			m.admitUser(w)
+		}
+		m.broadcastLobby()
+	}
+}
+
+/* Let a member waiting in the lobby into the meeting */
+func (m *Meeting) admitUser(w *User) {
+	delete(m.waiting, w.Token())
+	w.Info.waiting = false
+	m.enter(w)
+}
+
+/* Admit or reject a member waiting in the lobby, or everybody waiting if targetuserid is zero */
+func (m *Meeting) admitOrReject(u *User, targetuserid int, admit bool) {
+	var targets []*User
+	for _, w := range m.waiting {
+		if targetuserid == 0 || w.Info.keyid == targetuserid {
+			targets = append(targets, w)
+		}
+	}
+	if targetuserid != 0 && len(targets) == 0 {
+		m.sendErrorTo(u, "User not found in the lobby")
+		return
+	}
+
+	for _, w := range targets {
+		if admit {
+			log.Printf("Member %s admitted to meeting %d by %s", w.Info.name, m.meetingid, u.Info.name)
+			m.admitUser(w)
+		} else {
+			/* Still flagged as waiting, so leaving afterwards is not recorded as leaving the meeting */
+			log.Printf("Member %s rejected from meeting %d by %s", w.Info.name, m.meetingid, u.Info.name)
+			delete(m.waiting, w.Token())
+			m.disconnectUser(w, "You have not been admitted to this meeting")
+		}
+	}
+	m.broadcastLobby()
+}
+
+/***********************************************************************
+ * Quorum
+ ***********************************************************************/
+
+/*
+ * Count the members present and announce when quorum is reached or lost.
+ * Every connected key counts once, whether it is used by the member or by
+ * a proxy representing them.
+ */
+func (m *Meeting) checkQuorum() {
+	present := 0
+	for _, u := range m.users {
+		if u.Info.connected {
+			present++
+		}
+	}
+	if present == m.present {
+		return
+	}
+	m.present = present
+
+	quorate := m.quorum <= 0 || present >= m.quorum
+	if quorate != m.quorate {
+		m.quorate = quorate
+		/* Nobody cares about quorum once the meeting is over */
+		if m.state != MeetingStateFinished {
+			params := EventParams{"present": present, "quorum": m.quorum}
+			if quorate {
+				m.storeAndBroadcastEvent(EventQuorumReached, params)
+			} else {
+				m.storeAndBroadcastEvent(EventQuorumLost, params)
+			}
+		}
+	}
+	m.broadcastMeetingState()
+}
+
+func (m *Meeting) setQuorumRequired(u *User, required bool) {
+	if m.quorum <= 0 {
+		m.sendErrorTo(u, "This meeting has no quorum set")
+		return
+	}
+	if m.quorumrequired == required {
+		return
+	}
+
+	_, err := m.db.Exec("UPDATE membership_meeting SET quorumrequired=$1 WHERE id=$2", required, m.meetingid)
+	if err != nil {
+		log.Printf("Failed to update quorum requirement in database: %v", err)
+		m.sendErrorTo(u, "Failed to update state in database")
+		return
+	}
+	m.quorumrequired = required
+	log.Printf("Quorum requirement for meeting %d set to %v by %s", m.meetingid, required, u.Info.name)
+	m.broadcastMeetingState()
+}
+
+/***********************************************************************
+ * Scheduled state changes
+ ***********************************************************************/
+
+/*
+ * Open or finish the meeting when the scheduled time has come, and warn
+ * everybody ahead of a scheduled finish. The schedule is re-read every time
+ * since it can be changed in the database while the meeting is running.
+ */
+func (m *Meeting) checkSchedule() {
+	var start, end, handled sql.NullTime
+	row := m.db.QueryRow("SELECT scheduled_start, scheduled_end, scheduled_end_handled FROM membership_meeting WHERE id=$1", m.meetingid)
+	if err := row.Scan(&start, &end, &handled); err != nil {
+		log.Printf("Failed to read schedule for meeting %d: %s", m.meetingid, err)
+		return
+	}
+
+	now := time.Now()
+	scheduled := EventParams{"by": -1, "byname": "", "scheduled": true}
+
+	if m.state == MeetingStatePreOpen && start.Valid && !start.Time.After(now) {
+		if m.quorumrequired && !m.quorate {
+			/* Keep checking, the meeting opens as soon as quorum is reached */
+			log.Printf("Scheduled start of meeting %d reached, but waiting for quorum", m.meetingid)
+		} else {
+			log.Printf("Scheduled start of meeting %d reached, opening", m.meetingid)
+			m.changeMeetingState(true, scheduled)
+		}
+	}
+
+	if (m.state != MeetingStateOpen && m.state != MeetingStateRecess) || !end.Valid {
+		return
+	}
+
+	/* Start over with the warnings if the end time was changed */
+	if !end.Time.Equal(m.scheduledend) {
+		m.scheduledend = end.Time
+		m.finishwarnings = make(map[int]bool)
+	}
+
+	if !end.Time.After(now) {
+		/*
+		 * Only finish once, so an admin can re-open the meeting after the
+		 * scheduled end. This is kept in the database, since the meeting may
+		 * be restarted after that.
+		 */
+		if handled.Valid && handled.Time.Equal(end.Time) {
+			return
+		}
+		if _, err := m.db.Exec("UPDATE membership_meeting SET scheduled_end_handled=$2 WHERE id=$1", m.meetingid, end.Time); err != nil {
+			log.Printf("Failed to record scheduled end of meeting %d as handled: %s", m.meetingid, err)
+			/* Finish anyway, it is only re-opening that will not work */
+		}
+
+		log.Printf("Scheduled end of meeting %d reached, finishing", m.meetingid)
+		m.changeMeetingState(false, scheduled)
+		m.closeIfFinishedAndEmpty()
+		return
+	}
+
+	/* Send the closest warning not yet sent, and skip any earlier ones we missed */
+	remaining := end.Time.Sub(now)
+	warn := 0
+	for _, w := range config.finishwarnings {
+		if remaining <= time.Duration(w)*time.Minute && !m.finishwarnings[w] && (warn == 0 || w < warn) {
+			warn = w
+		}
+	}
+	if warn == 0 {
+		return
+	}
+	for _, w := range config.finishwarnings {
+		if w >= warn {
+			m.finishwarnings[w] = true
+		}
+	}
+	m.storeAndBroadcastEvent(EventMeetingFinishWarning, EventParams{
+		"minutes": int((remaining + time.Minute - 1) / time.Minute),
+		"time":    m.formatTime(end.Time),
+	})
+}
+
+func (m *Meeting) setSlowMode(u *User, seconds int) {
+	if seconds == m.slowmode {
+		return
+	}
+
+	m.slowmode = seconds
+	if seconds > 0 {
+		m.storeAndBroadcastEvent(EventSlowModeEnabled, EventParams{"by": u.Info.keyid, "byname": u.Info.name, "seconds": seconds})
+	} else {
+		m.storeAndBroadcastEvent(EventSlowModeDisabled, EventParams{"by": u.Info.keyid, "byname": u.Info.name})
+	}
+	m.broadcastMeetingState()
+}
+
+/***********************************************************************
+ * Pinned messages
+ ***********************************************************************/
+func (m *Meeting) queryPinned() ([]msgMessage, error) {
+	rows, err := m.db.Query(messageQuery+"WHERE ml.meeting_id=$1 AND ml.pinned ORDER BY ml.id", m.meetingid)
+	if err != nil {
+		return nil, err
+	}
+	return m.scanMessages(rows)
+}
+
+func (m *Meeting) sendPinnedTo(to *User) {
+	data, err := m.queryPinned()
+	if err != nil {
+		log.Println("Failed to query pinned messages:", err)
+		return
+	}
+	m.sendJsonTo(to, MakeMessage("pinned", m.localizeMessages(data, to.Info.language)))
+}
+
+func (m *Meeting) broadcastPinned() {
+	data, err := m.queryPinned()
+	if err != nil {
+		log.Println("Failed to query pinned messages:", err)
+		return
+	}
+
+	rendered := make(map[string]Msg)
+	for _, user := range m.users {
+		if !user.Info.connected {
+			continue
+		}
+		msg, ok := rendered[user.Info.language]
+		if !ok {
+			localized := make([]msgMessage, len(data))
+			copy(localized, data)
+			msg = MakeMessage("pinned", m.localizeMessages(localized, user.Info.language))
+			rendered[user.Info.language] = msg
+		}
+		m.queueJsonTo(user, msg)
+	}
+}
+
+/*
+ * Pin or unpin a message. If a message text is given, it is first posted as
+ * a new message from the user and then pinned.
+ */
+func (m *Meeting) pinMessage(user *User, messageid int, message string, pin bool) {
+	if message != "" {
+		messageid = m.storeAndBroadcast(message, user)
+		if messageid == 0 {
+			m.sendErrorTo(user, "Failed to post message")
+			return
+		}
+	}
+
+	var pinned bool
+	row := m.db.QueryRow("SELECT pinned FROM membership_meetingmessagelog WHERE meeting_id=$1 AND id=$2", m.meetingid, messageid)
+	if err := row.Scan(&pinned); err != nil {
+		if err != sql.ErrNoRows {
+			log.Println("Failed to check pinned message:", err)
+		}
+		m.sendErrorTo(user, "Message not found")
+		return
+	}
+	if pinned == pin {
+		if pin {
+			m.sendErrorTo(user, "Message is already pinned")
+		} else {
+			m.sendErrorTo(user, "Message is not pinned")
+		}
+		return
+	}
+
+	_, err := m.db.Exec("UPDATE membership_meetingmessagelog SET pinned=$3 WHERE meeting_id=$1 AND id=$2", m.meetingid, messageid, pin)
+	if err != nil {
+		m.sendErrorTo(user, "Failed to update pinned state in database")
+		log.Printf("Failed to update pinned state in database: %v", err)
+		return
+	}
+
+	params := EventParams{"message": messageid, "by": user.Info.keyid, "byname": user.Info.name}
+	if pin {
+		m.storeAndBroadcastEvent(EventMessagePin, params)
+	} else {
+		m.storeAndBroadcastEvent(EventMessageUnpin, params)
+	}
+	m.broadcastPinned()
+}
+
+/***********************************************************************
+ * Q&A
+ ***********************************************************************/
+
+/* Get the ranked list of questions, admins also see dismissed questions and who voted */
+func (m *Meeting) getQuestionsStruct(moderator bool) msgQuestions {
+	questions := []msgQuestion{}
+	for _, q := range RankQuestions(m.questions) {
+		if q.State == QuestionStateDismissed && !moderator {
+			continue
 		}
-		if m.state == MeetingStateFinished {
-			m.storeAndBroadcast(fmt.Sprintf("This meeting is being re-opened by %s", u.Info.name), nil)
+		mq := msgQuestion{
+			Id:       q.Id,
+			From:     q.From,
+			FromName: q.FromName,
+			Question: q.Text,
+			Votes:    q.VoteCount(),
+			State:    QuestionStateMap[q.State],
 		}
-		m.state = MeetingStateOpen
-		m.storeAndBroadcast("This meeting is now open", nil)
-		m.storeAndBroadcast("Anything sent from now on will be part of the permanent record", nil)
+		if moderator {
+			mq.Voters = q.Voters()
+		}
+		questions = append(questions, mq)
+	}
+	return msgQuestions{Questions: questions}
+}
+
+func (m *Meeting) sendQuestionsTo(to *User) {
+	m.sendJsonTo(to, MakeMessage("questions", m.getQuestionsStruct(to.Info.permissions.Has(PermissionModerate))))
+}
+
+func (m *Meeting) broadcastQuestions() {
+	m.broadcastJsonByPermission(PermissionModerate, MakeMessage("questions", m.getQuestionsStruct(true)), MakeMessage("questions", m.getQuestionsStruct(false)), nil)
+}
+
+func (m *Meeting) openOrCloseQA(u *User, doopen bool) {
+	if m.qaopen == doopen {
+		if doopen {
+			m.sendErrorTo(u, "Q&A is already open")
+		} else {
+			m.sendErrorTo(u, "Q&A is already closed")
+		}
+		return
+	}
+
+	m.qaopen = doopen
+	if doopen {
+		m.storeAndBroadcastEvent(EventQAOpen, EventParams{"by": u.Info.keyid, "byname": u.Info.name})
 	} else {
-		if m.state == MeetingStateFinished {
-			m.sendErrorTo(u, "Meeting is already finished")
+		m.storeAndBroadcastEvent(EventQAClose, EventParams{"by": u.Info.keyid, "byname": u.Info.name})
+	}
+	m.broadcastMeetingState()
+}
+
+func (m *Meeting) askQuestion(u *User, text string) {
+	if !m.qaopen {
+		m.sendErrorTo(u, "Q&A is not open")
+		return
+	}
+	if u.Info.muted {
+		m.sendErrorTo(u, "You have been muted and cannot post messages")
+		return
+	}
+
+	var id int
+	row := m.db.QueryRow("INSERT INTO membership_meetingquestion(meeting_id, key_id, t, question, state) VALUES ($1, $2, CURRENT_TIMESTAMP, $3, $4) RETURNING id", m.meetingid, u.Info.keyid, text, QuestionStateOpen)
+	if err := row.Scan(&id); err != nil {
+		m.sendErrorTo(u, "Failed to store question")
+		log.Println("Could not insert question:", err)
+		return
+	}
+
+	m.questions[id] = NewQuestion(id, u.Info.keyid, u.Info.name, text)
+	m.broadcastQuestions()
+}
+
+func (m *Meeting) upvoteQuestion(u *User, questionid int, upvote bool) {
+	q, ok := m.questions[questionid]
+	if !ok || (q.State == QuestionStateDismissed && !u.Info.permissions.Has(PermissionModerate)) {
+		m.sendErrorTo(u, "Question not found")
+		return
+	}
+	if q.State != QuestionStateOpen {
+		m.sendErrorTo(u, "Question is no longer open")
+		return
+	}
+	if q.From == u.Info.keyid {
+		m.sendErrorTo(u, "You cannot upvote your own question")
+		return
+	}
+	if !q.Upvote(u.Info.keyid, upvote) {
+		/* Nothing changed, so nothing to store or tell anybody */
+		return
+	}
+
+	var err error
+	if upvote {
+		_, err = m.db.Exec("INSERT INTO membership_meetingquestionvote(question_id, key_id) VALUES ($1, $2) ON CONFLICT DO NOTHING", questionid, u.Info.keyid)
+	} else {
+		_, err = m.db.Exec("DELETE FROM membership_meetingquestionvote WHERE question_id=$1 AND key_id=$2", questionid, u.Info.keyid)
+	}
+	if err != nil {
+		/* Undo the in-memory change so we stay consistent with the db */
+		q.Upvote(u.Info.keyid, !upvote)
+		m.sendErrorTo(u, "Failed to store vote")
+		log.Println("Could not update question vote:", err)
+		return
+	}
+
+	m.broadcastQuestions()
+}
+
+func (m *Meeting) markQuestion(u *User, questionid int, state int) {
+	q, ok := m.questions[questionid]
+	if !ok {
+		m.sendErrorTo(u, "Question not found")
+		return
+	}
+	if q.State == state {
+		return
+	}
+
+	_, err := m.db.Exec("UPDATE membership_meetingquestion SET state=$3 WHERE meeting_id=$1 AND id=$2", m.meetingid, questionid, state)
+	if err != nil {
+		m.sendErrorTo(u, "Failed to update question in database")
+		log.Printf("Failed to update question state in database: %v", err)
+		return
+	}
+	q.State = state
+
+	m.broadcastQuestions()
+}
+
+/***********************************************************************
+ * Agenda
+ ***********************************************************************/
+func (m *Meeting) getAgendaStruct() msgAgenda {
+	a := msgAgenda{Items: []msgAgendaItem{}, Current: m.agendaitem}
+	for _, item := range m.agenda {
+		a.Items = append(a.Items, msgAgendaItem{Id: item.Id, Title: item.Title})
+	}
+	return a
+}
+
+func (m *Meeting) sendAgendaTo(to *User) {
+	m.sendJsonTo(to, MakeMessage("agenda", m.getAgendaStruct()))
+}
+
+func (m *Meeting) broadcastAgenda() {
+	m.broadcastJson(MakeMessage("agenda", m.getAgendaStruct()), nil)
+}
+
+/* Change the current agenda item, with an item id of zero meaning the next one */
+func (m *Meeting) setAgendaItem(u *User, itemid int) {
+	var idx int
+	if itemid == 0 {
+		idx = AgendaIndex(m.agenda, m.agendaitem) + 1
+		if idx >= len(m.agenda) {
+			m.sendErrorTo(u, "There are no more agenda items")
 			return
 		}
-		m.state = MeetingStateFinished
-		m.storeAndBroadcast("This meeting is now finished", nil)
+	} else {
+		idx = AgendaIndex(m.agenda, itemid)
+		if idx < 0 {
+			m.sendErrorTo(u, "Agenda item not found")
+			return
+		}
+	}
+	item := m.agenda[idx]
+	if item.Id == m.agendaitem {
+		return
 	}
-	_, err := m.db.Exec("UPDATE membership_meeting SET state=$1 WHERE id=$2", m.state, m.meetingid)
+
+	_, err := m.db.Exec("UPDATE membership_meeting SET current_agendaitem_id=$2 WHERE id=$1", m.meetingid, item.Id)
 	if err != nil {
-		m.sendErrorTo(u, "Failed to update state in database")
-		log.Printf("Failed to update meeting state in database: %v", err)
+		m.sendErrorTo(u, "Failed to update agenda in database")
+		log.Printf("Failed to update current agenda item in database: %v", err)
 		return
 	}
-	m.broadcastMeetingState()
+	m.agendaitem = item.Id
+
+	m.broadcastAgenda()
+	m.storeAndBroadcastEvent(EventAgendaItem, EventParams{"item": item.Id, "number": idx + 1, "title": item.Title, "by": u.Info.keyid, "byname": u.Info.name})
 }
 
 /***********************************************************************
  * Polls
  ***********************************************************************/
-func (m *Meeting) getPollStatusStruct(admin bool) *msgPollStatus {
+func (m *Meeting) getPollStatusStruct(voters bool) *msgPollStatus {
 	if m.activepoll == nil {
 		return nil
 	}
 
 	p := msgPollStatus{
-		Question: m.activepoll.Question,
-		Answers:  m.activepoll.Answers,
-		Tally:    m.activepoll.Tally(),
+		Question:   m.activepoll.Question,
+		Answers:    m.activepoll.Answers,
+		Tally:      m.activepoll.Tally(),
+		AgendaItem: m.activepoll.AgendaItem,
 	}
-	if admin {
+	if voters {
 		p.Voted = m.activepoll.Voted()
 	}
 
@@ -492,17 +1959,32 @@ func (m *Meeting) newPoll(user *User, question string, answers []string, minutes
 		m.sendErrorTo(user, "There is already an active poll")
 		return
 	}
+	if m.state == MeetingStateRecess {
+		m.sendErrorTo(user, "Voting is suspended during the recess")
+		return
+	}
 
-	m.activepoll = NewPoll(question, answers)
+	m.pollcount++
+	m.activepoll = NewPoll(m.pollcount, question, answers)
+	m.activepoll.AgendaItem = m.agendaitem
 
 	m.broadcastPollStatus()
-	m.storeAndBroadcast(fmt.Sprintf("A new poll has been posted for %s", question), nil)
+	m.storeAndBroadcastEvent(EventPollOpen, EventParams{"poll": m.activepoll.Id, "question": question, "answers": answers, "agendaitem": m.agendaitem, "minutes": minutes})
+	m.publish(PublishPollOpen, map[string]interface{}{"poll": m.activepoll.Id, "question": question, "answers": answers})
+
+	m.startPollTimer(time.Duration(minutes) * time.Minute)
+}
 
-	/* Start a timer to close the poll */
-	timer := time.NewTimer(time.Duration(minutes) * time.Minute)
+/* Start a timer to close the active poll */
+func (m *Meeting) startPollTimer(d time.Duration) {
+	poll := m.activepoll
+	timer := time.NewTimer(d)
 	go func() {
 		<-timer.C
-		m.polltimer <- m.activepoll
+		select {
+		case m.polltimer <- poll:
+		case <-m.done:
+		}
 	}()
 }
 
@@ -512,6 +1994,11 @@ func (m *Meeting) castVote(question string, vote int, user *User) {
 		return
 	}
 
+	if m.state == MeetingStateRecess {
+		m.sendErrorTo(user, "Voting is suspended during the recess")
+		return
+	}
+
 	if question != m.activepoll.Question {
 		m.sendErrorTo(user, "Vote for the wrong question received")
 		return
@@ -522,37 +2009,105 @@ func (m *Meeting) castVote(question string, vote int, user *User) {
 		return
 	}
 
-	changed := m.activepoll.CastVote(user.Info.keyid, vote)
-	if changed {
-		m.storeAndBroadcast(fmt.Sprintf("%s changed their vote to %s", user.Info.name, m.activepoll.Answers[vote]), nil)
-	} else {
-		m.storeAndBroadcast(fmt.Sprintf("%s voted %s", user.Info.name, m.activepoll.Answers[vote]), nil)
+	m.recordVote(user.Info.keyid, user.Info.name, vote, nil)
+}
+
+/* Enter a vote cast on paper by a member, who does not need to be connected */
+func (m *Meeting) castPaperVote(teller *User, keyid int, question string, vote int) {
+	if m.activepoll == nil {
+		m.sendErrorTo(teller, "There is no active poll")
+		return
+	}
+	if m.state == MeetingStateRecess {
+		m.sendErrorTo(teller, "Voting is suspended during the recess")
+		return
+	}
+	if question != m.activepoll.Question {
+		m.sendErrorTo(teller, "Vote for the wrong question received")
+		return
+	}
+	if vote < 0 || vote >= len(m.activepoll.Answers) {
+		m.sendErrorTo(teller, "Invalid vote")
+		return
+	}
+
+	var name string
+	row := m.db.QueryRow(`SELECT fullname FROM membership_membermeetingkey mk
+INNER JOIN membership_member m ON m.user_id=mk.member_id
+WHERE mk.meeting_id=$1 AND mk.id=$2`, m.meetingid, keyid)
+	if err := row.Scan(&name); err != nil {
+		if err != sql.ErrNoRows {
+			log.Printf("Failed to look up member for paper vote: %s", err)
+		}
+		m.sendErrorTo(teller, "User not found")
+		return
+	}
+
+	m.recordVote(keyid, name, vote, teller)
+}
+
+/* Record a validated vote, entered by a teller if it was cast on paper */
+func (m *Meeting) recordVote(keyid int, name string, vote int, teller *User) {
+	changed := m.activepoll.CastVote(keyid, vote)
+	params := EventParams{
+		"poll":       m.activepoll.Id,
+		"member":     keyid,
+		"name":       name,
+		"answer":     vote,
+		"answertext": m.activepoll.Answers[vote],
+		"changed":    changed,
 	}
+	if teller != nil {
+		params["paper"] = true
+		params["by"] = teller.Info.keyid
+		params["byname"] = teller.Info.name
+	}
+	m.storeAndBroadcastEvent(EventPollVote, params)
 
-	if m.activepoll.VoteCount() == len(m.users) {
-		m.closePoll("All attendees have voted, poll has completed.")
+	if m.allVoted() {
+		m.closePoll(PollCloseAllVoted)
 	} else {
 		m.broadcastPollStatus()
 	}
 }
 
-func (m *Meeting) closePoll(msg string) {
+/*
+ * Have all connected members voted. Votes from members who are not
+ * connected, such as paper votes or votes cast before a restart by members
+ * who have not come back, don't count towards this.
+ */
+func (m *Meeting) allVoted() bool {
+	connected := 0
+	for _, u := range m.users {
+		if !u.Info.connected {
+			continue
+		}
+		if !m.activepoll.HasVoted(u.Info.keyid) {
+			return false
+		}
+		connected++
+	}
+	return connected > 0
+}
+
+func (m *Meeting) closePoll(reason string) {
 	if m.activepoll == nil {
 		/* Can't happen, really */
 		return
 	}
 
 	tally := m.activepoll.Tally()
-	m.storeAndBroadcast(msg, nil)
+	m.storeAndBroadcastEvent(EventPollClose, EventParams{"poll": m.activepoll.Id, "reason": reason, "tally": tally[:len(m.activepoll.Answers)]})
 	for i, a := range m.activepoll.Answers {
-		var plural string
-		if tally[i] == 1 {
-			plural = ""
-		} else {
-			plural = "s"
-		}
-		m.storeAndBroadcast(fmt.Sprintf("Answer \"%s\": %d vote%s", a, tally[i], plural), nil)
+		m.storeAndBroadcastEvent(EventPollResult, EventParams{"poll": m.activepoll.Id, "answer": i, "answertext": a, "votes": tally[i]})
 	}
+	m.publish(PublishPollClose, map[string]interface{}{
+		"poll":     m.activepoll.Id,
+		"question": m.activepoll.Question,
+		"answers":  m.activepoll.Answers,
+		"reason":   reason,
+		"tally":    tally[:len(m.activepoll.Answers)],
+	})
 	m.activepoll = nil
 	m.broadcastPollStatus()
 }
@@ -563,35 +2118,44 @@ func (m *Meeting) abortPoll(user *User) {
 		return
 	}
 
+	m.abortActivePoll(user.Info.keyid, user.Info.name)
+}
+
+/* Abort the active poll, by a member or by -1 for pgeu-system */
+func (m *Meeting) abortActivePoll(by int, byname string) {
+	m.storeAndBroadcastEvent(EventPollAbort, EventParams{"poll": m.activepoll.Id, "by": by, "byname": byname})
+	m.publish(PublishPollAbort, map[string]interface{}{"poll": m.activepoll.Id})
 	m.activepoll = nil
-	m.storeAndBroadcast("The current poll has has been aborted", nil)
 	m.broadcastPollStatus()
 }
 
 func (m *Meeting) pollTimerFired(poll *Poll) {
 	if m.activepoll == poll {
-		m.closePoll("Poll has completed")
+		m.closePoll(PollCloseTimeout)
 	}
 }
 
 /***********************************************************************
  * User administration
  ***********************************************************************/
-func (m *Meeting) kickUser(user *User, targetuserid int, canrejoin bool) {
-	var targetuser *User
+func (m *Meeting) findUser(keyid int) *User {
 	for _, u := range m.users {
-		if u.Info.keyid == targetuserid {
-			targetuser = u
-			break
+		if u.Info.keyid == keyid {
+			return u
 		}
 	}
+	return nil
+}
+
+func (m *Meeting) kickUser(user *User, targetuserid int, canrejoin bool) {
+	targetuser := m.findUser(targetuserid)
 	if targetuser == nil {
 		m.sendErrorTo(user, "User to kick not found")
 		return
 	}
 	targetuser.Info.allowrejoin = canrejoin // XXX: Don't set from here!
-	targetuser.Disconnect <- "You have been forcibly disconnected from this meeting"
-	m.storeAndBroadcast(fmt.Sprintf("User %s has been disconnected by %s", targetuser.Info.name, user.Info.name), nil)
+	m.disconnectUser(targetuser, "You have been forcibly disconnected from this meeting")
+	m.storeAndBroadcastEvent(EventMemberKick, EventParams{"member": targetuser.Info.keyid, "name": targetuser.Info.name, "by": user.Info.keyid, "byname": user.Info.name, "canrejoin": canrejoin})
 	m.broadcastUserJoinLeave(targetuser, false)
 
 	/* If block rejoins, we must do so in the db as well! */
@@ -604,6 +2168,315 @@ func (m *Meeting) kickUser(user *User, targetuserid int, canrejoin bool) {
 	}
 }
 
+func (m *Meeting) muteUser(user *User, targetuserid int, mute bool) {
+	targetuser := m.findUser(targetuserid)
+	if targetuser == nil {
+		m.sendErrorTo(user, "User to mute not found")
+		return
+	}
+	if targetuser.Info.muted == mute {
+		if mute {
+			m.sendErrorTo(user, "User is already muted")
+		} else {
+			m.sendErrorTo(user, "User is not muted")
+		}
+		return
+	}
+
+	/* Mute state is kept per membership key, so it survives reconnects */
+	_, err := m.db.Exec("UPDATE membership_membermeetingkey SET muted=$3 WHERE meeting_id=$1 AND id=$2", m.meetingid, targetuser.Info.keyid, mute)
+	if err != nil {
+		m.sendErrorTo(user, "Failed to update mute state in database")
+		log.Printf("Failed to update mute state in database: %v", err)
+		return
+	}
+	targetuser.Info.muted = mute
+
+	params := EventParams{"member": targetuser.Info.keyid, "name": targetuser.Info.name, "by": user.Info.keyid, "byname": user.Info.name}
+	if mute {
+		m.storeAndBroadcastEvent(EventMemberMute, params)
+	} else {
+		m.storeAndBroadcastEvent(EventMemberUnmute, params)
+	}
+	m.broadcastUserUpdate(targetuser)
+}
+
+/* Make a connected member an administrator of the meeting, or remove them as one */
+func (m *Meeting) promoteUser(user *User, targetuserid int, admin bool) {
+	targetuser := m.findUser(targetuserid)
+	if targetuser == nil || !targetuser.Info.connected {
+		m.sendErrorTo(user, "User not found")
+		return
+	}
+	if targetuser == user {
+		/* Or a chair could make themselves a full administrator */
+		m.sendErrorTo(user, "You cannot change your own administrator status")
+		return
+	}
+	if targetuser.Info.admin == admin {
+		if admin {
+			m.sendErrorTo(user, "User is already an administrator")
+		} else {
+			m.sendErrorTo(user, "User is not an administrator")
+		}
+		return
+	}
+	if !admin {
+		/* Somebody has to be left to run the meeting */
+		others := false
+		for _, u := range m.users {
+			if u != targetuser && u.Info.connected && u.Info.admin {
+				others = true
+				break
+			}
+		}
+		if !others {
+			m.sendErrorTo(user, "Cannot remove the last connected administrator")
+			return
+		}
+	}
+
+	var err error
+	if admin {
+		_, err = m.db.Exec("INSERT INTO membership_meeting_meetingadmins(meeting_id, member_id) SELECT $1, $2 WHERE NOT EXISTS (SELECT 1 FROM membership_meeting_meetingadmins WHERE meeting_id=$1 AND member_id=$2)", m.meetingid, targetuser.Info.authid)
+	} else {
+		_, err = m.db.Exec("DELETE FROM membership_meeting_meetingadmins WHERE meeting_id=$1 AND member_id=$2", m.meetingid, targetuser.Info.authid)
+	}
+	if err != nil {
+		m.sendErrorTo(user, "Failed to update administrators in database")
+		log.Printf("Failed to update administrators in database: %v", err)
+		return
+	}
+
+	params := EventParams{"member": targetuser.Info.keyid, "name": targetuser.Info.name, "by": user.Info.keyid, "byname": user.Info.name}
+	if admin {
+		m.storeAndBroadcastEvent(EventMemberPromote, params)
+	} else {
+		m.storeAndBroadcastEvent(EventMemberDemote, params)
+	}
+	m.setUserAdmin(targetuser, admin)
+}
+
+/***********************************************************************
+ * Live control from pgeu-system
+ ***********************************************************************/
+
+/* Pass a notification to the meeting, unless it has stopped */
+func (m *Meeting) Notify(notification Notification) {
+	select {
+	case m.Notifications <- notification:
+	case <-m.done:
+	}
+}
+
+func (m *Meeting) handleNotification(notification Notification) {
+	log.Printf("Received %s notification for meeting %d", notification.Type, m.meetingid)
+	switch notification.Type {
+	case NotifyKeys:
+		m.refreshKeys()
+	case NotifyAdmins:
+		m.refreshRoles()
+	case NotifyState:
+		m.refreshState()
+	case NotifyRefresh:
+		m.refreshKeys()
+		m.refreshRoles()
+		m.refreshState()
+	default:
+		log.Printf("Unknown notification type %s", notification.Type)
+	}
+}
+
+/* Disconnect anybody whose key has been removed */
+func (m *Meeting) refreshKeys() {
+	rows, err := m.db.Query("SELECT id FROM membership_membermeetingkey WHERE meeting_id=$1", m.meetingid)
+	if err != nil {
+		log.Printf("Failed to load keys for meeting %d: %s", m.meetingid, err)
+		return
+	}
+	defer rows.Close()
+
+	keys := make(map[int]bool)
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			log.Printf("Failed to load keys for meeting %d: %s", m.meetingid, err)
+			return
+		}
+		keys[id] = true
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Failed to load keys for meeting %d: %s", m.meetingid, err)
+		return
+	}
+
+	for _, u := range m.users {
+		if u.Info.connected && !keys[u.Info.keyid] {
+			log.Printf("Key of member %s has been revoked, disconnecting", u.Info.name)
+			/* Not connected from now on, so the next refresh does not disconnect them again */
+			u.Info.connected = false
+			m.endAttendance(u)
+			m.disconnectUser(u, "Your access to this meeting has been revoked")
+		}
+	}
+	for token, u := range m.waiting {
+		if !keys[u.Info.keyid] {
+			log.Printf("Key of member %s has been revoked, removing from lobby", u.Info.name)
+			m.disconnectUser(u, "Your access to this meeting has been revoked")
+			delete(m.waiting, token)
+			m.broadcastLobby()
+		}
+	}
+}
+
+/* Re-read who are administrators of the meeting, and the roles of members */
+func (m *Meeting) refreshRoles() {
+	rows, err := m.db.Query(`SELECT mk.id,
+EXISTS (SELECT 1 FROM membership_meeting_meetingadmins a WHERE a.meeting_id=mk.meeting_id AND a.member_id=mk.member_id),
+ARRAY(SELECT role FROM membership_meetingrole r WHERE r.meeting_id=mk.meeting_id AND r.member_id=mk.member_id ORDER BY role)
+FROM membership_membermeetingkey mk
+WHERE mk.meeting_id=$1`, m.meetingid)
+	if err != nil {
+		log.Printf("Failed to load roles for meeting %d: %s", m.meetingid, err)
+		return
+	}
+	defer rows.Close()
+
+	admins := make(map[int]bool)
+	roles := make(map[int][]string)
+	for rows.Next() {
+		var id int
+		var admin bool
+		var r pq.StringArray
+		if err := rows.Scan(&id, &admin, &r); err != nil {
+			log.Printf("Failed to load roles for meeting %d: %s", m.meetingid, err)
+			return
+		}
+		admins[id] = admin
+		roles[id] = []string(r)
+	}
+	if err := rows.Err(); err != nil {
+		log.Printf("Failed to load roles for meeting %d: %s", m.meetingid, err)
+		return
+	}
+
+	for _, u := range m.users {
+		r := roles[u.Info.keyid]
+		if u.Info.admin != admins[u.Info.keyid] || strings.Join(u.Info.roles, ",") != strings.Join(r, ",") {
+			u.Info.admin = admins[u.Info.keyid]
+			u.Info.roles = r
+			m.updatePermissions(u)
+		}
+	}
+}
+
+/* Change if a user is an administrator */
+func (m *Meeting) setUserAdmin(u *User, admin bool) {
+	u.Info.admin = admin
+	m.updatePermissions(u)
+}
+
+/* Recalculate the permissions of a user, and give them the matching view of the meeting */
+func (m *Meeting) updatePermissions(u *User) {
+	u.Info.permissions = PermissionsFor(u.Info.admin, u.Info.roles)
+	log.Printf("Member %s now has admin=%v roles=%v in meeting %d", u.Info.name, u.Info.admin, u.Info.roles, m.meetingid)
+
+	if !u.Info.connected {
+		return
+	}
+	m.sendSelfTo(u)
+	m.sendUserListTo(u)
+	m.sendPollStatusTo(u)
+	m.sendQuestionsTo(u)
+	if u.Info.permissions.Has(PermissionModerate) {
+		m.sendLobbyTo(u)
+	}
+	m.broadcastUserUpdate(u)
+}
+
+/* Apply a change of state made in the database */
+func (m *Meeting) refreshState() {
+	var state int
+	var resume sql.NullTime
+	var lobby, locked bool
+	row := m.db.QueryRow("SELECT state, recess_until, lobby, locked FROM membership_meeting WHERE id=$1", m.meetingid)
+	if err := row.Scan(&state, &resume, &lobby, &locked); err != nil {
+		log.Printf("Failed to read state of meeting %d: %s", m.meetingid, err)
+		return
+	}
+	if lobby != m.lobby {
+		log.Printf("Lobby of meeting %d set to %v in database", m.meetingid, lobby)
+		m.changeLobby(lobby)
+	}
+	if locked != m.locked {
+		log.Printf("Meeting %d locked set to %v in database", m.meetingid, locked)
+		m.locked = locked
+		m.broadcastMeetingState()
+	}
+	if state == m.state && resume.Time.Equal(m.resume) {
+		return
+	}
+	log.Printf("State of meeting %d changed to %s in database", m.meetingid, MeetingStateMap[state])
+
+	params := EventParams{"by": -1, "byname": ""}
+	switch state {
+	case MeetingStateOpen, MeetingStateFinished:
+		if state != m.state {
+			m.changeMeetingState(state == MeetingStateOpen, params)
+			m.closeIfFinishedAndEmpty()
+		}
+	case MeetingStateRecess:
+		if m.activepoll != nil {
+			/* Unlike a recess asked for in the meeting it cannot be refused, so the poll goes */
+			log.Printf("Aborting poll %d of meeting %d for recess set in database", m.activepoll.Id, m.meetingid)
+			m.abortActivePoll(-1, "")
+		}
+		m.state = MeetingStateRecess
+		m.resume = resume.Time
+		params["resume"] = nil
+		if resume.Valid {
+			params["resume"] = m.formatTime(resume.Time)
+		}
+		m.storeAndBroadcastEvent(EventMeetingRecess, params)
+		m.broadcastMeetingState()
+		m.publishState()
+	case MeetingStatePreOpen:
+		m.state = MeetingStatePreOpen
+		m.broadcastMeetingState()
+		m.publishState()
+	case MeetingStateClosed:
+		m.state = MeetingStateClosed
+		m.publishState()
+		m.disconnectAll("This meeting has been closed")
+		m.stopchannel <- true
+	}
+}
+
+/***********************************************************************
+ * Attendance
+ ***********************************************************************/
+func (m *Meeting) sendAttendanceTo(to *User) {
+	records, err := LoadAttendance(m.db, m.meetingid)
+	if err != nil {
+		log.Println("Failed to load attendance:", err)
+		m.sendErrorTo(to, "Failed to load attendance")
+		return
+	}
+
+	attendance := msgAttendanceList{Members: []msgAttendance{}}
+	for _, r := range records {
+		attendance.Members = append(attendance.Members, msgAttendance{
+			Id:        r.KeyId,
+			Name:      r.Name,
+			Proxy:     r.Proxy,
+			Connected: r.Connected,
+			Seconds:   int(r.Total.Seconds()),
+			Polls:     r.Polls,
+		})
+	}
+	m.sendJsonTo(to, MakeMessage("attendance", attendance))
+}
+
 /***********************************************************************
  * Status reporting
  ***********************************************************************/
@@ -628,5 +2501,16 @@ func (m *Meeting) reportStatus(reportchan chan *MeetingStatus) {
 	if status.Members == nil {
 		status.Members = make([]MemberStatus, 0)
 	}
+	status.Locked = m.locked
+	status.Lobby = m.lobby
+	status.WaitingMembers = make([]MemberStatus, 0)
+	for _, u := range m.waiting {
+		status.WaitingMembers = append(status.WaitingMembers, MemberStatus{
+			Uid:    u.Info.authid,
+			Name:   u.Info.name,
+			Admin:  u.Info.admin,
+			Remote: u.Remote(),
+		})
+	}
 	reportchan <- status
 }
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"database/sql"
+	"testing"
+	"time"
+)
+
+/* A meeting with no database, which any handler that gets to run will trip over */
+func newTestMeeting() *Meeting {
+	return &Meeting{
+		meetingid:      1,
+		state:          MeetingStateOpen,
+		location:       time.UTC,
+		language:       DefaultLanguage,
+		users:          make(map[string]*User),
+		waiting:        make(map[string]*User),
+		ratelimiters:   make(map[int]*RateLimiter),
+		questions:      make(map[int]*Question),
+		finishwarnings: make(map[int]bool),
+		colors:         newColorAssigner(),
+		polltimer:      make(chan *Poll),
+		stopchannel:    make(chan bool, 1),
+		done:           make(chan struct{}),
+	}
+}
+
+func newTestUser(m *Meeting, permissions Permission) *User {
+	u := &User{
+		meeting:    m,
+		Send:       make(chan interface{}, 100),
+		Disconnect: make(chan string, 1),
+		token:      "testtoken",
+	}
+	u.Info = UserInfo{
+		keyid:       1,
+		name:        "Test Member",
+		connected:   true,
+		language:    DefaultLanguage,
+		permissions: permissions,
+	}
+	u.Info.presence = u.Presence()
+	m.users[u.token] = u
+	return u
+}
+
+/* Arguments that would make every privileged action go through if it was allowed */
+func testAction(action int, u *User) MeetingUseraction {
+	return MeetingUseraction{
+		action:       action,
+		user:         u,
+		message:      "Question",
+		vote:         1,
+		open:         true,
+		answers:      []string{"Yes", "No"},
+		minutes:      5,
+		seconds:      10,
+		messageid:    1,
+		targetuserid: u.Info.keyid,
+	}
+}
+
+func TestPrivilegedActionsAreDenied(t *testing.T) {
+	var audited []string
+	defer func(f func(*sql.DB, int, int, string, string) error) { recordDeniedAction = f }(recordDeniedAction)
+	recordDeniedAction = func(db *sql.DB, meetingid int, keyid int, action string, permission string) error {
+		audited = append(audited, action)
+		return nil
+	}
+
+	for action, p := range actionPermissions {
+		/* Both without any permissions, and with every permission except the one needed */
+		for _, permissions := range []Permission{0, PermissionAll &^ p.permission} {
+			audited = nil
+			m := newTestMeeting()
+			u := newTestUser(m, permissions)
+
+			func() {
+				defer func() {
+					if r := recover(); r != nil {
+						t.Errorf("%s with permissions %v: handler was reached: %v", p.what, permissions.Names(), r)
+					}
+				}()
+				m.handleAction(testAction(action, u))
+			}()
+
+			if len(u.Send) != 1 {
+				t.Errorf("%s with permissions %v: expected 1 message, got %d", p.what, permissions.Names(), len(u.Send))
+				continue
+			}
+			msg, ok := (<-u.Send).(ErrorMsg)
+			if !ok || msg.Msg != "Permission denied" {
+				t.Errorf("%s with permissions %v: expected permission denied error, got %v", p.what, permissions.Names(), msg)
+			}
+			if len(u.Disconnect) != 0 {
+				t.Errorf("%s with permissions %v: user was disconnected", p.what, permissions.Names())
+			}
+			if len(audited) != 1 || audited[0] != p.what {
+				t.Errorf("%s with permissions %v: expected denial in audit log, got %v", p.what, permissions.Names(), audited)
+			}
+			if m.state != MeetingStateOpen || m.slowmode != 0 || m.qaopen || m.lobby || m.locked || m.activepoll != nil {
+				t.Errorf("%s with permissions %v: meeting was changed", p.what, permissions.Names())
+			}
+		}
+	}
+}
+
+func TestPermissionsForRoles(t *testing.T) {
+	if PermissionsFor(true, nil) != PermissionAll {
+		t.Errorf("admin does not have all permissions")
+	}
+	if PermissionsFor(false, nil) != 0 {
+		t.Errorf("member without roles has permissions")
+	}
+	if p := PermissionsFor(false, []string{RoleTeller, "unknown"}); p != PermissionVoters {
+		t.Errorf("teller has permissions %v", p.Names())
+	}
+}
+
+func TestNoVotesDuringRecess(t *testing.T) {
+	m := newTestMeeting()
+	m.state = MeetingStateRecess
+	m.activepoll = NewPoll(1, "Question", []string{"Yes", "No"})
+	u := newTestUser(m, PermissionAll)
+
+	for _, action := range []int{ActionVote, ActionPaperVote} {
+		m.handleAction(testAction(action, u))
+		if len(u.Send) != 1 {
+			t.Fatalf("vote action %d: expected 1 message, got %d", action, len(u.Send))
+		}
+		if msg, ok := (<-u.Send).(ErrorMsg); !ok || msg.Msg != "Voting is suspended during the recess" {
+			t.Errorf("vote action %d: expected voting suspended error, got %v", action, msg)
+		}
+		if m.activepoll.HasVoted(u.Info.keyid) {
+			t.Errorf("vote action %d: vote was recorded", action)
+		}
+	}
+}
+
+func TestDeniedActionsAreThrottled(t *testing.T) {
+	audits := 0
+	defer func(f func(*sql.DB, int, int, string, string) error) { recordDeniedAction = f }(recordDeniedAction)
+	recordDeniedAction = func(db *sql.DB, meetingid int, keyid int, action string, permission string) error {
+		audits++
+		return nil
+	}
+	defer func(rate float64, burst int) { config.ratelimit, config.rateburst = rate, burst }(config.ratelimit, config.rateburst)
+	config.ratelimit, config.rateburst = 1, 2
+
+	m := newTestMeeting()
+	u := newTestUser(m, 0)
+	for i := 0; i < 5; i++ {
+		m.handleAction(testAction(ActionOpenFinish, u))
+	}
+
+	if audits != 2 || len(u.Send) != 2 {
+		t.Errorf("expected 2 denials recorded and reported, got %d recorded and %d reported", audits, len(u.Send))
+	}
+}
+
+func TestActionsFromOutsideTheMeetingAreIgnored(t *testing.T) {
+	for _, refuse := range []string{"refused", "disconnected"} {
+		m := newTestMeeting()
+		m.activepoll = NewPoll(1, "Question", []string{"Yes", "No"})
+		u := newTestUser(m, PermissionAll)
+		if refuse == "refused" {
+			delete(m.users, u.token)
+		} else {
+			u.Info.connected = false
+		}
+
+		for _, action := range []int{ActionVote, ActionMessage} {
+			func() {
+				defer func() {
+					if r := recover(); r != nil {
+						t.Errorf("action %d from %s user: handler was reached: %v", action, refuse, r)
+					}
+				}()
+				m.handleAction(testAction(action, u))
+			}()
+			if len(u.Send) != 0 {
+				t.Errorf("action %d from %s user: got %d messages", action, refuse, len(u.Send))
+			}
+		}
+		if m.activepoll.HasVoted(u.Info.keyid) {
+			t.Errorf("vote from %s user was recorded", refuse)
+		}
+	}
+}
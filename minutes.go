@@ -0,0 +1,427 @@
+package main
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/lib/pq"
+	htmltemplate "html/template"
+	"log"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+/*
+ * Generation of meeting minutes from the permanent record, as Markdown or
+ * HTML. The minutes are built entirely from the database, so they can be
+ * generated both while the meeting is running and long after.
+ */
+
+type MinutesAttendee struct {
+	Name     string
+	Proxy    string
+	Duration string
+	Polls    []int
+}
+
+type MinutesEntry struct {
+	Time    string
+	From    string
+	Message string
+}
+
+type MinutesPollResult struct {
+	Answer string
+	Votes  int
+}
+
+type MinutesPoll struct {
+	Id       int
+	Time     string
+	Question string
+	Results  []MinutesPollResult
+	Aborted  bool
+	Present  int
+}
+
+/* Everything that happened during one agenda item, or outside of the agenda */
+type MinutesSection struct {
+	AgendaItem int
+	Number     int
+	Title      string
+	Polls      []*MinutesPoll
+	Transcript []MinutesEntry
+}
+
+type Minutes struct {
+	MeetingId    int
+	Name         string
+	Date         string
+	Language     string
+	Attendees    []MinutesAttendee
+	StateChanges []MinutesEntry
+	Sections     []*MinutesSection
+}
+
+/* Build the minutes of a meeting from the database */
+func BuildMinutes(db *sql.DB, meetingid int) (*Minutes, error) {
+	var timezone, language sql.NullString
+	minutes := &Minutes{MeetingId: meetingid}
+	row := db.QueryRow("SELECT name, timezone, language FROM membership_meeting WHERE id=$1", meetingid)
+	if err := row.Scan(&minutes.Name, &timezone, &language); err != nil {
+		return nil, err
+	}
+	location := meetingLocation(meetingid, timezone)
+	minutes.Language = meetingLanguage(meetingid, language)
+
+	agenda, err := LoadAgenda(db, meetingid)
+	if err != nil {
+		return nil, err
+	}
+
+	/* There is always a section for anything outside the agenda, and one per agenda item */
+	sections := make(map[int]*MinutesSection)
+	sections[0] = &MinutesSection{}
+	minutes.Sections = append(minutes.Sections, sections[0])
+	for i, item := range agenda {
+		sections[item.Id] = &MinutesSection{AgendaItem: item.Id, Number: i + 1, Title: item.Title}
+		minutes.Sections = append(minutes.Sections, sections[item.Id])
+	}
+
+	rows, err := db.Query(`SELECT ml.t,
+ml.sender_id IS NOT NULL,
+COALESCE(fullname, ''),
+message,
+eventtype,
+eventparams,
+agendaitem_id
+FROM membership_meetingmessagelog ml
+LEFT JOIN membership_member ON membership_member.user_id=ml.sender_id
+WHERE ml.meeting_id=$1
+ORDER BY ml.id`, meetingid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	polls := make(map[int]*MinutesPoll)
+	for rows.Next() {
+		var t time.Time
+		var fromuser bool
+		var fromname, message string
+		var eventtype sql.NullString
+		var eventparams []byte
+		var agendaitem sql.NullInt64
+
+		if err := rows.Scan(&t, &fromuser, &fromname, &message, &eventtype, &eventparams, &agendaitem); err != nil {
+			return nil, err
+		}
+		t = t.In(location)
+		if minutes.Date == "" {
+			minutes.Date = t.Format("2006-01-02")
+		}
+		section, ok := sections[int(agendaitem.Int64)]
+		if !ok {
+			/* Agenda item has since been removed */
+			section = sections[0]
+		}
+
+		if fromuser {
+			section.Transcript = append(section.Transcript, MinutesEntry{Time: t.Format("15:04"), From: fromname, Message: message})
+			continue
+		}
+		if !eventtype.Valid {
+			continue
+		}
+
+		var params EventParams
+		if err := json.Unmarshal(eventparams, &params); err != nil {
+			return nil, err
+		}
+		normalizeEventParams(params)
+		pollid, _ := params["poll"].(int)
+
+		switch eventtype.String {
+		case EventMeetingOpen, EventMeetingReopen, EventMeetingRecess, EventMeetingResume, EventMeetingFinish:
+			minutes.StateChanges = append(minutes.StateChanges, MinutesEntry{Time: t.Format("15:04"), Message: message})
+		case EventPollOpen:
+			p := &MinutesPoll{Id: pollid, Time: t.Format("15:04")}
+			p.Question, _ = params["question"].(string)
+			polls[pollid] = p
+			section.Polls = append(section.Polls, p)
+		case EventPollResult:
+			if p, ok := polls[pollid]; ok {
+				r := MinutesPollResult{}
+				r.Answer, _ = params["answertext"].(string)
+				r.Votes, _ = params["votes"].(int)
+				p.Results = append(p.Results, r)
+			}
+		case EventPollAbort:
+			if p, ok := polls[pollid]; ok {
+				p.Aborted = true
+			}
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	attendance, err := LoadAttendance(db, meetingid)
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range attendance {
+		minutes.Attendees = append(minutes.Attendees, MinutesAttendee{
+			Name:     r.Name,
+			Proxy:    r.Proxy,
+			Duration: formatDuration(r.Total),
+			Polls:    r.Polls,
+		})
+		for _, p := range r.Polls {
+			if poll, ok := polls[p]; ok {
+				poll.Present++
+			}
+		}
+	}
+	return minutes, nil
+}
+
+/* Format a duration as hours and minutes */
+func formatDuration(d time.Duration) string {
+	minutes := int(d.Round(time.Minute).Minutes())
+	return fmt.Sprintf("%d:%02d", minutes/60, minutes%60)
+}
+
+const minutesMarkdown = `# {{md .Name}}
+
+{{tr "Date"}}: {{.Date}}
+
+## {{tr "Attendance"}}
+{{range .Attendees}}
+- {{md .Name}}{{with .Proxy}} ({{tr "through proxy"}} {{md .}}){{end}}, {{tr "present"}} {{.Duration}}{{with .Polls}}, {{tr "polls"}} {{join .}}{{end}}{{else}}
+{{tr "Nobody attended this meeting"}}{{end}}
+
+## {{tr "Proceedings"}}
+{{range .StateChanges}}
+- {{.Time}} {{md .Message}}{{end}}
+{{range .Sections}}{{if or .Title .Polls .Transcript}}
+## {{if .Title}}{{.Number}}. {{md .Title}}{{else}}{{tr "General"}}{{end}}
+{{range .Polls}}
+### {{tr "Poll"}}: {{md .Question}}
+
+{{tr "Started at"}} {{.Time}}, {{.Present}} {{tr "present"}}{{if .Aborted}}, {{tr "aborted"}}{{end}}
+{{range .Results}}
+- {{md .Answer}}: {{.Votes}}{{end}}
+{{end}}{{if .Transcript}}
+### {{tr "Transcript"}}
+{{range .Transcript}}
+- {{.Time}} **{{md .From}}**: {{md .Message}}{{end}}
+{{end}}{{end}}{{end}}`
+
+const minutesHtml = `<!DOCTYPE html>
+<html lang="{{.Language}}">
+<head>
+<meta charset="utf-8">
+<title>{{.Name}}</title>
+</head>
+<body>
+<h1>{{.Name}}</h1>
+<p>{{tr "Date"}}: {{.Date}}</p>
+<h2>{{tr "Attendance"}}</h2>
+{{if .Attendees}}<ul>
+{{range .Attendees}}<li>{{.Name}}{{with .Proxy}} ({{tr "through proxy"}} {{.}}){{end}}, {{tr "present"}} {{.Duration}}{{with .Polls}}, {{tr "polls"}} {{join .}}{{end}}</li>
+{{end}}</ul>
+{{else}}<p>{{tr "Nobody attended this meeting"}}</p>
+{{end}}<h2>{{tr "Proceedings"}}</h2>
+<ul>
+{{range .StateChanges}}<li>{{.Time}} {{.Message}}</li>
+{{end}}</ul>
+{{range .Sections}}{{if or .Title .Polls .Transcript}}<h2>{{if .Title}}{{.Number}}. {{.Title}}{{else}}{{tr "General"}}{{end}}</h2>
+{{range .Polls}}<h3>{{tr "Poll"}}: {{.Question}}</h3>
+<p>{{tr "Started at"}} {{.Time}}, {{.Present}} {{tr "present"}}{{if .Aborted}}, {{tr "aborted"}}{{end}}</p>
+{{if .Results}}<ul>
+{{range .Results}}<li>{{.Answer}}: {{.Votes}}</li>
+{{end}}</ul>
+{{end}}{{end}}{{if .Transcript}}<h3>{{tr "Transcript"}}</h3>
+<ul>
+{{range .Transcript}}<li>{{.Time}} <strong>{{.From}}</strong>: {{.Message}}</li>
+{{end}}</ul>
+{{end}}{{end}}{{end}}</body>
+</html>
+`
+
+/*
+ * Escape text from members and the database for use in markdown, so it can
+ * not add formatting, and flatten line breaks so it can not start headings
+ * or list items of its own in the minutes.
+ */
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", `*`, `\*`, `_`, `\_`, `[`, `\[`, `]`, `\]`,
+	`<`, `\<`, `>`, `\>`, `|`, `\|`, `~`, `\~`, `#`, `\#`,
+)
+
+func escapeMarkdown(s string) string {
+	return markdownEscaper.Replace(strings.Join(strings.Fields(s), " "))
+}
+
+/* Render minutes as either markdown or html */
+func RenderMinutes(minutes *Minutes, format string) (string, error) {
+	tr := func(s string) string {
+		return translate(minutes.Language, s)
+	}
+	join := func(ids []int) string {
+		s := make([]string, len(ids))
+		for i, id := range ids {
+			s[i] = strconv.Itoa(id)
+		}
+		return strings.Join(s, ", ")
+	}
+
+	var b bytes.Buffer
+	switch format {
+	case "markdown":
+		t, err := texttemplate.New("minutes").Funcs(texttemplate.FuncMap{"tr": tr, "join": join, "md": escapeMarkdown}).Parse(minutesMarkdown)
+		if err != nil {
+			return "", err
+		}
+		if err := t.Execute(&b, minutes); err != nil {
+			return "", err
+		}
+	case "html":
+		t, err := htmltemplate.New("minutes").Funcs(htmltemplate.FuncMap{"tr": tr, "join": join}).Parse(minutesHtml)
+		if err != nil {
+			return "", err
+		}
+		if err := t.Execute(&b, minutes); err != nil {
+			return "", err
+		}
+	default:
+		return "", fmt.Errorf("unknown format %s", format)
+	}
+	return b.String(), nil
+}
+
+/***********************************************************************
+ * Commandline and http access
+ ***********************************************************************/
+
+/* Run the minutes subcommand, writing the minutes of one meeting to stdout */
+func MinutesCommand(args []string) int {
+	fs := flag.NewFlagSet("minutes", flag.ExitOnError)
+	dburl := fs.String("dburl", "postgres:///postgresqleu", "PostgreSQL connection URL")
+	format := fs.String("format", "markdown", "Output format, markdown or html")
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s minutes [-dburl url] [-format format] meetingid\n", os.Args[0])
+		fs.PrintDefaults()
+	}
+	fs.Parse(args)
+
+	if fs.NArg() != 1 {
+		fs.Usage()
+		return 2
+	}
+	meetingid, err := strconv.Atoi(fs.Arg(0))
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Invalid meeting id %s\n", fs.Arg(0))
+		return 2
+	}
+
+	db, err := sql.Open("postgres", *dburl)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not connect to database: %s\n", err)
+		return 1
+	}
+	defer db.Close()
+
+	minutes, err := BuildMinutes(db, meetingid)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not build minutes: %s\n", err)
+		return 1
+	}
+	out, err := RenderMinutes(minutes, *format)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Could not render minutes: %s\n", err)
+		return 1
+	}
+	fmt.Print(out)
+	return 0
+}
+
+var minutesUrlPattern = regexp.MustCompile("^/minutes/(\\d+)/([A-Za-z0-9_-]{54})\\.(md|html)$")
+
+/*
+ * Serve the minutes of a meeting. Access is granted using the meeting key
+ * of a member who is an administrator of the meeting, the same way as the
+ * websocket itself.
+ */
+func MinutesHandler(w http.ResponseWriter, r *http.Request) {
+	match := minutesUrlPattern.FindStringSubmatch(r.URL.Path)
+	if len(match) == 0 {
+		http.NotFound(w, r)
+		return
+	}
+	meetingid, err := strconv.Atoi(match[1])
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	token := match[2]
+
+	db, err := sql.Open("postgres", config.db_url)
+	if err != nil {
+		log.Printf("Could not connect to database for minutes: %s", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	defer db.Close()
+
+	/* Minutes can be generated by anybody allowed to see the record */
+	var admin bool
+	var roles pq.StringArray
+	row := db.QueryRow(`SELECT EXISTS (SELECT 1 FROM membership_meeting_meetingadmins a WHERE a.meeting_id=mk.meeting_id AND a.member_id=mk.member_id),
+ARRAY(SELECT role FROM membership_meetingrole r WHERE r.meeting_id=mk.meeting_id AND r.member_id=mk.member_id)
+FROM membership_membermeetingkey mk
+WHERE mk.meeting_id=$1 AND mk.key=$2`, meetingid, token)
+	if err := row.Scan(&admin, &roles); err != nil && err != sql.ErrNoRows {
+		log.Printf("Could not check access to minutes: %s", err)
+		http.Error(w, "Database error", http.StatusInternalServerError)
+		return
+	}
+	if !PermissionsFor(admin, roles).Has(PermissionRecord) {
+		http.Error(w, "Permission denied", http.StatusForbidden)
+		return
+	}
+
+	minutes, err := BuildMinutes(db, meetingid)
+	if err == sql.ErrNoRows {
+		http.NotFound(w, r)
+		return
+	} else if err != nil {
+		log.Printf("Could not build minutes for meeting %d: %s", meetingid, err)
+		http.Error(w, "Could not build minutes", http.StatusInternalServerError)
+		return
+	}
+
+	var format string
+	if match[3] == "md" {
+		format = "markdown"
+		w.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+	} else {
+		format = "html"
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	}
+	out, err := RenderMinutes(minutes, format)
+	if err != nil {
+		log.Printf("Could not render minutes for meeting %d: %s", meetingid, err)
+		http.Error(w, "Could not render minutes", http.StatusInternalServerError)
+		return
+	}
+	w.Write([]byte(out))
+}
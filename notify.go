@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"github.com/lib/pq"
+	"log"
+	"time"
+)
+
+/*
+ * Live control from pgeu-system. Changes made in the web interface while a
+ * meeting is running are sent with NOTIFY on the channel we LISTEN on, with
+ * a json payload identifying the meeting and what has changed. The meeting
+ * then re-reads that part from the database.
+ */
+const (
+	NotifyKeys    = "keys"
+	NotifyAdmins  = "admins"
+	NotifyState   = "state"
+	NotifyRefresh = "refresh"
+)
+
+type Notification struct {
+	Meeting int    `json:"meeting"`
+	Type    string `json:"type"`
+}
+
+func NotificationListener() {
+	listener := pq.NewListener(config.db_url, 10*time.Second, time.Minute, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			log.Printf("Notification listener: %s", err)
+		}
+	})
+	if err := listener.Listen(config.notifychannel); err != nil {
+		log.Fatalf("Could not listen for notifications on %s: %s", config.notifychannel, err)
+	}
+
+	for {
+		select {
+		case n := <-listener.Notify:
+			if n == nil {
+				/* The connection was re-established, so we may have missed something */
+				log.Printf("Notification listener reconnected, refreshing all meetings")
+				for _, m := range RunningMeetings() {
+					m.Notify(Notification{Meeting: m.meetingid, Type: NotifyRefresh})
+				}
+				continue
+			}
+
+			var notification Notification
+			if err := json.Unmarshal([]byte(n.Extra), &notification); err != nil {
+				log.Printf("Failed to parse notification %s: %s", n.Extra, err)
+				continue
+			}
+			/* Meetings that are not running read everything when they start */
+			if m := GetRunningMeeting(notification.Meeting); m != nil {
+				m.Notify(notification)
+			}
+		case <-time.After(90 * time.Second):
+			/* Make sure the connection is still alive */
+			go listener.Ping()
+		}
+	}
+}
+
+/*
+ * Events published to the web application with NOTIFY, so it can follow
+ * running meetings without polling the status endpoint. The payload is a
+ * json object with the meeting id, the event and its own fields.
+ */
+const (
+	PublishState      = "state"
+	PublishPollOpen   = "pollopen"
+	PublishPollClose  = "pollclose"
+	PublishPollAbort  = "pollabort"
+	PublishAttendance = "attendance"
+)
+
+func (m *Meeting) publish(event string, fields map[string]interface{}) {
+	if config.publishchannel == "" {
+		return
+	}
+
+	payload := map[string]interface{}{"meeting": m.meetingid, "event": event}
+	for k, v := range fields {
+		payload[k] = v
+	}
+	j, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("Failed to encode %s event for meeting %d: %s", event, m.meetingid, err)
+		return
+	}
+
+	/* Nothing in the meeting depends on this, so errors are only logged */
+	if _, err := m.db.Exec("SELECT pg_notify($1, $2)", config.publishchannel, string(j)); err != nil {
+		log.Printf("Failed to publish %s event for meeting %d: %s", event, m.meetingid, err)
+	}
+}
+
+func (m *Meeting) publishState() {
+	m.publish(PublishState, map[string]interface{}{"state": MeetingStateMap[m.state]})
+}
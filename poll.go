@@ -1,13 +1,22 @@
 package main
 
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+)
+
 type Poll struct {
-	Question string
-	Answers  []string
-	votes    map[int]int
+	Id         int
+	Question   string
+	Answers    []string
+	AgendaItem int
+	votes      map[int]int
 }
 
-func NewPoll(question string, answers []string) *Poll {
+func NewPoll(id int, question string, answers []string) *Poll {
 	return &Poll{
+		Id:       id,
 		Question: question,
 		Answers:  answers,
 		votes:    make(map[int]int),
@@ -18,6 +27,11 @@ func (p *Poll) VoteCount() int {
 	return len(p.votes)
 }
 
+func (p *Poll) HasVoted(userid int) bool {
+	_, voted := p.votes[userid]
+	return voted
+}
+
 func (p *Poll) Tally() [5]int {
 	tally := [5]int{0, 0, 0, 0, 0}
 	for _, v := range p.votes {
@@ -42,3 +56,76 @@ func (p *Poll) CastVote(userid int, vote int) bool {
 	p.votes[userid] = vote
 	return already
 }
+
+/*
+ * Load the poll that was running when the meeting was last stopped, if any,
+ * along with the time it is due to close (zero if it has no time limit).
+ * Polls and votes are stored as events as they happen, so that is all that
+ * is needed to continue a poll across a restart of the server.
+ */
+func LoadActivePoll(db *sql.DB, meetingid int) (*Poll, time.Time, error) {
+	var opened time.Time
+	var eventparams []byte
+	row := db.QueryRow("SELECT t, eventparams FROM membership_meetingmessagelog WHERE meeting_id=$1 AND eventtype=$2 ORDER BY id DESC LIMIT 1", meetingid, EventPollOpen)
+	if err := row.Scan(&opened, &eventparams); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, time.Time{}, nil
+		}
+		return nil, time.Time{}, err
+	}
+
+	var params EventParams
+	if err := json.Unmarshal(eventparams, &params); err != nil {
+		return nil, time.Time{}, err
+	}
+	normalizeEventParams(params)
+	pollid, _ := params["poll"].(int)
+
+	var finished bool
+	row = db.QueryRow("SELECT EXISTS (SELECT 1 FROM membership_meetingmessagelog WHERE meeting_id=$1 AND eventtype IN ($2, $3) AND (eventparams->>'poll')::int=$4)", meetingid, EventPollClose, EventPollAbort, pollid)
+	if err := row.Scan(&finished); err != nil {
+		return nil, time.Time{}, err
+	}
+	if finished {
+		return nil, time.Time{}, nil
+	}
+
+	question, _ := params["question"].(string)
+	var answers []string
+	if a, ok := params["answers"].([]interface{}); ok {
+		for _, s := range a {
+			answer, _ := s.(string)
+			answers = append(answers, answer)
+		}
+	}
+	poll := NewPoll(pollid, question, answers)
+	poll.AgendaItem, _ = params["agendaitem"].(int)
+
+	var closes time.Time
+	if minutes, _ := params["minutes"].(int); minutes > 0 {
+		closes = opened.Add(time.Duration(minutes) * time.Minute)
+	}
+
+	/* Replay the votes, where a later vote replaces an earlier one */
+	rows, err := db.Query("SELECT eventparams FROM membership_meetingmessagelog WHERE meeting_id=$1 AND eventtype=$2 AND (eventparams->>'poll')::int=$3 ORDER BY id", meetingid, EventPollVote, pollid)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var voteparams EventParams
+		if err := rows.Scan(&eventparams); err != nil {
+			return nil, time.Time{}, err
+		}
+		if err := json.Unmarshal(eventparams, &voteparams); err != nil {
+			return nil, time.Time{}, err
+		}
+		normalizeEventParams(voteparams)
+		member, _ := voteparams["member"].(int)
+		answer, _ := voteparams["answer"].(int)
+		if answer >= 0 && answer < len(answers) {
+			poll.CastVote(member, answer)
+		}
+	}
+	return poll, closes, rows.Err()
+}
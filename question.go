@@ -0,0 +1,128 @@
+package main
+
+import (
+	"database/sql"
+	"sort"
+)
+
+/* State of a question in Q&A mode */
+const (
+	QuestionStateOpen      = 0
+	QuestionStateAnswered  = 1
+	QuestionStateDismissed = 2
+)
+
+var QuestionStateMap = map[int]string{
+	QuestionStateOpen:      "open",
+	QuestionStateAnswered:  "answered",
+	QuestionStateDismissed: "dismissed",
+}
+
+type Question struct {
+	Id       int
+	From     int
+	FromName string
+	Text     string
+	State    int
+	votes    map[int]bool
+}
+
+func NewQuestion(id int, from int, fromname string, text string) *Question {
+	return &Question{
+		Id:       id,
+		From:     from,
+		FromName: fromname,
+		Text:     text,
+		State:    QuestionStateOpen,
+		votes:    make(map[int]bool),
+	}
+}
+
+func (q *Question) VoteCount() int {
+	return len(q.votes)
+}
+
+func (q *Question) Voters() []int {
+	voters := make([]int, 0, len(q.votes))
+	for k := range q.votes {
+		voters = append(voters, k)
+	}
+	sort.Ints(voters)
+	return voters
+}
+
+/* Add or remove the upvote of a user, returning false if nothing changed */
+func (q *Question) Upvote(userid int, upvote bool) bool {
+	if q.votes[userid] == upvote {
+		return false
+	}
+	if upvote {
+		q.votes[userid] = true
+	} else {
+		delete(q.votes, userid)
+	}
+	return true
+}
+
+/* Rank questions with open ones first, then by number of votes, then by age */
+func RankQuestions(questions map[int]*Question) []*Question {
+	ranked := make([]*Question, 0, len(questions))
+	for _, q := range questions {
+		ranked = append(ranked, q)
+	}
+	sort.Slice(ranked, func(i, j int) bool {
+		a, b := ranked[i], ranked[j]
+		if a.State != b.State {
+			return a.State < b.State
+		}
+		if a.VoteCount() != b.VoteCount() {
+			return a.VoteCount() > b.VoteCount()
+		}
+		return a.Id < b.Id
+	})
+	return ranked
+}
+
+/* Load all questions and their votes for a meeting */
+func LoadQuestions(db *sql.DB, meetingid int) (map[int]*Question, error) {
+	questions := make(map[int]*Question)
+
+	rows, err := db.Query(`SELECT q.id, q.key_id, COALESCE(m.fullname, ''), q.question, q.state
+FROM membership_meetingquestion q
+LEFT JOIN membership_membermeetingkey mk ON mk.id=q.key_id
+LEFT JOIN membership_member m ON m.user_id=mk.member_id
+WHERE q.meeting_id=$1`, meetingid)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		q := NewQuestion(0, 0, "", "")
+		if err := rows.Scan(&q.Id, &q.From, &q.FromName, &q.Text, &q.State); err != nil {
+			return nil, err
+		}
+		questions[q.Id] = q
+	}
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	vrows, err := db.Query(`SELECT v.question_id, v.key_id
+FROM membership_meetingquestionvote v
+INNER JOIN membership_meetingquestion q ON q.id=v.question_id
+WHERE q.meeting_id=$1`, meetingid)
+	if err != nil {
+		return nil, err
+	}
+	defer vrows.Close()
+	for vrows.Next() {
+		var questionid, keyid int
+		if err := vrows.Scan(&questionid, &keyid); err != nil {
+			return nil, err
+		}
+		if q, ok := questions[questionid]; ok {
+			q.votes[keyid] = true
+		}
+	}
+	return questions, vrows.Err()
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"time"
+)
+
+/*
+ * Simple token bucket rate limiter. Tokens are added at a fixed rate up
+ * to the size of the burst, and each allowed event consumes one token.
+ * Not safe for concurrent use, it is expected to be owned by a single
+ * goroutine.
+ */
+type RateLimiter struct {
+	rate   float64 /* Tokens per second */
+	burst  float64
+	tokens float64
+	last   time.Time
+}
+
+func NewRateLimiter(perminute float64, burst int) *RateLimiter {
+	return &RateLimiter{
+		rate:   perminute / 60,
+		burst:  float64(burst),
+		tokens: float64(burst),
+		last:   time.Now(),
+	}
+}
+
+func (r *RateLimiter) Allow() bool {
+	/* A rate of zero means there is no limit */
+	if r.rate <= 0 {
+		return true
+	}
+
+	now := time.Now()
+	r.tokens += now.Sub(r.last).Seconds() * r.rate
+	if r.tokens > r.burst {
+		r.tokens = r.burst
+	}
+	r.last = now
+
+	if r.tokens < 1 {
+		return false
+	}
+	r.tokens--
+	return true
+}
@@ -0,0 +1,83 @@
+package main
+
+/*
+ * Roles and permissions. Administrators of a meeting can do everything,
+ * while other members can be given roles in the meeting that each grant
+ * the permissions needed for one job.
+ */
+type Permission uint64
+
+const (
+	/* Open, finish and recess the meeting, and require quorum */
+	PermissionState Permission = 1 << iota
+	/* Move through the agenda */
+	PermissionAgenda
+	/* Create and abort polls */
+	PermissionPolls
+	/* See who voted, and enter paper votes */
+	PermissionVoters
+	/* Kick and mute members, slow mode, and Q&A moderation */
+	PermissionModerate
+	/* Pin and unpin messages */
+	PermissionPin
+	/* View the attendance, and generate the minutes */
+	PermissionRecord
+	/* Promote and demote administrators */
+	PermissionAdmins
+
+	PermissionAll = PermissionState | PermissionAgenda | PermissionPolls | PermissionVoters | PermissionModerate | PermissionPin | PermissionRecord | PermissionAdmins
+)
+
+/* Names of the permissions, as used in the protocol */
+var PermissionMap = map[Permission]string{
+	PermissionState:    "state",
+	PermissionAgenda:   "agenda",
+	PermissionPolls:    "polls",
+	PermissionVoters:   "voters",
+	PermissionModerate: "moderate",
+	PermissionPin:      "pin",
+	PermissionRecord:   "record",
+	PermissionAdmins:   "admins",
+}
+
+const (
+	RoleChair     = "chair"
+	RoleSecretary = "secretary"
+	RoleTeller    = "teller"
+	RoleModerator = "moderator"
+)
+
+var RolePermissions = map[string]Permission{
+	RoleChair:     PermissionState | PermissionAgenda | PermissionPolls | PermissionAdmins,
+	RoleSecretary: PermissionRecord | PermissionPin,
+	RoleTeller:    PermissionVoters,
+	RoleModerator: PermissionModerate | PermissionPin,
+}
+
+/* Does this set of permissions include all of the specified ones */
+func (p Permission) Has(q Permission) bool {
+	return p&q == q
+}
+
+/* Names of the permissions in the set, in a stable order */
+func (p Permission) Names() []string {
+	names := []string{}
+	for q := PermissionState; q <= PermissionAdmins; q <<= 1 {
+		if p.Has(q) {
+			names = append(names, PermissionMap[q])
+		}
+	}
+	return names
+}
+
+/* Get the permissions of a member from their admin flag and roles, ignoring unknown roles */
+func PermissionsFor(admin bool, roles []string) Permission {
+	if admin {
+		return PermissionAll
+	}
+	var p Permission
+	for _, r := range roles {
+		p |= RolePermissions[r]
+	}
+	return p
+}
@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"database/sql"
 	"flag"
 	"fmt"
 	"github.com/gorilla/websocket"
@@ -11,30 +13,49 @@ import (
 	"os"
 	"os/signal"
 	"regexp"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 )
 
 var config = struct {
-	verify_origin string
-	db_url        string
-	behindproxy   bool
+	verify_origin   string
+	db_url          string
+	behindproxy     bool
+	ratelimit       float64
+	rateburst       int
+	historysize     int
+	finishwarnings  []int
+	idletimeout     int
+	shutdowntimeout int
+	notifychannel   string
+	publishchannel  string
 }{}
 
 var (
 	_meetings             = make(map[int]*Meeting)
 	_meetings_mutex       sync.RWMutex
-	_meeting_remover_chan = make(chan int, 10)
+	_meeting_remover_chan = make(chan *Meeting, 10)
 )
 
 func EnsureAndGetMeeting(meetingid int) *Meeting {
 	_meetings_mutex.RLock()
 	/* Cannot use defer on the unlock since we have to unlock/relock later */
 
+	/* No new meetings are started once we are shutting down */
+	if _shutting_down.Load() {
+		_meetings_mutex.RUnlock()
+		return nil
+	}
+
 	meeting, ok := _meetings[meetingid]
 
-	if ok {
+	/* A meeting that has stopped but not yet been removed is replaced */
+	if ok && !meeting.stopped() {
 		_meetings_mutex.RUnlock()
 		return meeting
 	}
@@ -53,9 +74,13 @@ func EnsureAndGetMeeting(meetingid int) *Meeting {
 	_meetings_mutex.RUnlock()
 	_meetings_mutex.Lock()
 	defer _meetings_mutex.Unlock()
-	_, ok = _meetings[meetingid]
-	if ok {
-		return _meetings[meetingid]
+	if existing, ok := _meetings[meetingid]; ok && !existing.stopped() {
+		meeting.db.Close()
+		return existing
+	}
+	if _shutting_down.Load() {
+		meeting.db.Close()
+		return nil
 	}
 
 	/*
@@ -67,26 +92,112 @@ func EnsureAndGetMeeting(meetingid int) *Meeting {
 	log.Printf("Started meeting %d", meetingid)
 	return meeting
 }
-func RemoveMeeting(id int) {
+func RemoveMeeting(m *Meeting) {
 	/*
 	* Delete a meeting from the list. If it doesn't exist, it just means
-	* it's already been deleted, so ignore that.
+	* it's already been deleted, so ignore that. If it has already been
+	* replaced by a new instance, that one must stay.
 	 */
 	_meetings_mutex.Lock()
 	defer _meetings_mutex.Unlock()
 
-	if _, ok := _meetings[id]; ok {
-		log.Printf("Removing meeting %d", id)
-		delete(_meetings, id)
+	if _meetings[m.meetingid] == m {
+		log.Printf("Removing meeting %d", m.meetingid)
+		delete(_meetings, m.meetingid)
+	}
+}
+
+/* Get the meeting if it is running, without starting it */
+func GetRunningMeeting(meetingid int) *Meeting {
+	_meetings_mutex.RLock()
+	defer _meetings_mutex.RUnlock()
+
+	meeting, ok := _meetings[meetingid]
+	if !ok || meeting.stopped() {
+		return nil
+	}
+	return meeting
+}
+
+/* Get a snapshot of the running meetings, to talk to without holding the lock */
+func RunningMeetings() []*Meeting {
+	_meetings_mutex.RLock()
+	defer _meetings_mutex.RUnlock()
+
+	meetings := make([]*Meeting, 0, len(_meetings))
+	for _, m := range _meetings {
+		meetings = append(meetings, m)
 	}
+	return meetings
 }
+
 func MeetingRemover() {
 	for {
-		id := <-_meeting_remover_chan
-		RemoveMeeting(id)
+		m := <-_meeting_remover_chan
+		RemoveMeeting(m)
 	}
 }
 
+/*
+ * Make sure meetings that are due to be opened or finished according to
+ * their schedule are running, so the state change happens even if nobody
+ * is connected. The meeting itself takes care of the actual change.
+ */
+func MeetingScheduler() {
+	db, err := sql.Open("postgres", config.db_url)
+	if err != nil {
+		log.Fatalf("Could not connect to database for scheduler: %s", err)
+	}
+	defer db.Close()
+
+	for {
+		rows, err := db.Query(`SELECT id FROM membership_meeting
+WHERE (state=$1 AND scheduled_start <= CURRENT_TIMESTAMP)
+OR (state IN ($2, $3) AND scheduled_end <= CURRENT_TIMESTAMP AND scheduled_end_handled IS DISTINCT FROM scheduled_end)`,
+			MeetingStatePreOpen, MeetingStateOpen, MeetingStateRecess)
+		if err != nil {
+			log.Printf("Failed to query scheduled meetings: %s", err)
+		} else {
+			var ids []int
+			for rows.Next() {
+				var id int
+				if err := rows.Scan(&id); err != nil {
+					log.Printf("Failed to parse scheduled meeting: %s", err)
+					break
+				}
+				ids = append(ids, id)
+			}
+			rows.Close()
+
+			for _, id := range ids {
+				EnsureAndGetMeeting(id)
+			}
+		}
+
+		time.Sleep(time.Minute)
+	}
+}
+
+/* Parse a comma separated list of minutes into a sorted list */
+func parseFinishWarnings(s string) ([]int, error) {
+	var warnings []int
+	if s == "" {
+		return warnings, nil
+	}
+	for _, p := range strings.Split(s, ",") {
+		w, err := strconv.Atoi(strings.TrimSpace(p))
+		if err != nil {
+			return nil, err
+		}
+		if w < 1 {
+			return nil, fmt.Errorf("warning must be at least one minute")
+		}
+		warnings = append(warnings, w)
+	}
+	sort.Ints(warnings)
+	return warnings, nil
+}
+
 var upgrader = websocket.Upgrader{
 	ReadBufferSize:  1024,
 	WriteBufferSize: 1024,
@@ -107,6 +218,11 @@ var upgrader = websocket.Upgrader{
 var wsUrlPattern = regexp.MustCompile("^/ws/meeting/(\\d+)/([A-Za-z0-9_-]{54})/(\\d+)")
 
 func wsHandler(w http.ResponseWriter, r *http.Request) {
+	if _shutting_down.Load() {
+		http.Error(w, "Server is restarting", http.StatusServiceUnavailable)
+		return
+	}
+
 	match := wsUrlPattern.FindStringSubmatch(r.URL.Path)
 	if len(match) == 0 {
 		http.NotFound(w, r)
@@ -147,59 +263,127 @@ func wsHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	user := newUser(meeting, conn, token, first, remote)
 
+	/*
+	 * Register the user with he meeting, which will perform the permissions
+	 * check. If the meeting was shut down for being idle since we looked it
+	 * up, it is started again.
+	 */
+	for !meeting.Join(user) {
+		meeting = EnsureAndGetMeeting(meetingid)
+		if meeting == nil {
+			conn.Close()
+			return
+		}
+		user.meeting = meeting
+	}
+
 	/* Start the background workers so we can accept both traffic and disconnect signals */
+	_connections.Add(1)
 	go user.writer()
 	go user.reader()
-
-	/* Register the user with he meeting, which will perform the permissions check */
-	meeting.Register <- user
 }
 
-/* Are we currently shutting down? In that case we avoid some error logging. */
-var _shutting_down bool = false
+/* Are we currently shutting down? In that case we avoid some error logging, and refuse new joins. */
+var _shutting_down atomic.Bool
 
-/* Listen on a specific host:port or unix socket, serving it using the specified mux */
-func doListenAndServe(listen string, mux *http.ServeMux) {
-	/* Listener starting with / indicates it's a Unix socket */
-	if string((listen)[0]) == "/" {
-		listener, err := net.Listen("unix", listen)
-		if err != nil {
-			log.Fatalf("Could not listen on unix socket %s: %s", listen, err)
-			return
-		}
+/* Number of websocket connections whose writer is still running */
+var _connections atomic.Int64
 
-		CleanupSocketOnExit(listener)
+/* Listen on a specific host:port or unix socket, serving it using the specified server */
+func doListenAndServe(listen string, server *http.Server) {
+	var listener net.Listener
+	var err error
 
-		err = http.Serve(listener, mux)
-		if err != nil && !_shutting_down {
-			log.Fatalf("Could not serve on unix socket %s: %s", listen, err)
-		}
+	/* Listener starting with / indicates it's a Unix socket */
+	if string((listen)[0]) == "/" {
+		listener, err = net.Listen("unix", listen)
 	} else {
-		err := http.ListenAndServe(listen, mux)
-		if err != nil {
-			log.Fatalf("Could not listen on %s: %s", listen, err)
-		}
+		listener, err = net.Listen("tcp", listen)
+	}
+	if err != nil {
+		log.Fatalf("Could not listen on %s: %s", listen, err)
+		return
+	}
+
+	/* Closing the listener on shutdown also removes the unix socket */
+	err = server.Serve(listener)
+	if err != nil && err != http.ErrServerClosed {
+		log.Fatalf("Could not serve on %s: %s", listen, err)
 	}
 }
 
-/* Register signal handlers to clean up unix socket, if unix socket is used */
-func CleanupSocketOnExit(listener net.Listener) {
+/*
+ * Register signal handlers to shut down gracefully. The listener is closed
+ * right away, and once requests other than websockets have completed the
+ * deadline for the rest of the shutdown is sent on the returned channel.
+ */
+func ShutdownOnSignal(server *http.Server) chan time.Time {
+	stopped := make(chan time.Time, 1)
 	sigc := make(chan os.Signal, 1)
 	signal.Notify(sigc, os.Interrupt, syscall.SIGTERM)
 	go func(c chan os.Signal) {
 		sig := <-c
 		log.Printf("Caught signal %s: shutting down.", sig)
+		deadline := time.Now().Add(time.Duration(config.shutdowntimeout) * time.Second)
+
 		/* Flag that we're shutting down, so we will not report an error */
-		_shutting_down = true
-		listener.Close()
-		os.Exit(0)
+		_shutting_down.Store(true)
+
+		ctx, cancel := context.WithDeadline(context.Background(), deadline)
+		defer cancel()
+		if err := server.Shutdown(ctx); err != nil {
+			log.Printf("Failed to wait for http requests to complete: %s", err)
+		}
+		stopped <- deadline
 	}(sigc)
+	return stopped
+}
+
+/*
+ * Shut down all running meetings, and wait for them to disconnect their
+ * members, until the deadline.
+ */
+func ShutdownMeetings(deadline time.Time) {
+	meetings := RunningMeetings()
+
+	for _, m := range meetings {
+		m.Shutdown()
+	}
+
+	timeout := time.NewTimer(time.Until(deadline))
+	defer timeout.Stop()
+	for _, m := range meetings {
+		select {
+		case <-m.done:
+		case <-timeout.C:
+			log.Printf("Timeout waiting for meetings to shut down")
+			return
+		}
+	}
+
+	/* Give the disconnect messages a chance to reach the members */
+	for _connections.Load() > 0 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+	}
 }
 
 func main() {
+	/* Subcommands are handled before the regular server flags are parsed */
+	if len(os.Args) > 1 && os.Args[1] == "minutes" {
+		os.Exit(MinutesCommand(os.Args[2:]))
+	}
+
 	flag.StringVar(&config.verify_origin, "origin", "", "Origin to verify")
 	flag.StringVar(&config.db_url, "dburl", "postgres:///postgresqleu", "PostgreSQL connection URL")
 	flag.BoolVar(&config.behindproxy, "behindproxy", false, "Behind proxy, decode x-forwarded-for")
+	flag.Float64Var(&config.ratelimit, "ratelimit", 20, "Maximum number of chat messages per minute per user (0 for no limit)")
+	flag.IntVar(&config.rateburst, "rateburst", 5, "Number of chat messages a user can send in a burst above the rate limit")
+	flag.IntVar(&config.historysize, "historysize", 100, "Number of messages to send on join and per history request")
+	flag.StringVar(&config.notifychannel, "notifychannel", "pgeu_meetingserver", "PostgreSQL channel to listen on for changes to running meetings (empty to not listen)")
+	flag.StringVar(&config.publishchannel, "publishchannel", "pgeu_meetingserver_events", "PostgreSQL channel to publish meeting events on (empty to not publish)")
+	flag.IntVar(&config.shutdowntimeout, "shutdowntimeout", 10, "Seconds to wait for meetings to shut down when stopping the server")
+	flag.IntVar(&config.idletimeout, "idletimeout", 30, "Minutes after which a meeting nobody is connected to is shut down (0 to never)")
+	finishwarnings := flag.String("finishwarnings", "10,5,1", "Comma separated list of minutes before a scheduled finish to warn attendees")
 	listen := flag.String("listen", "127.0.0.1:8199", "Host and port to listen to")
 	profilelisten := flag.String("profilelisten", "", "Host to listen for go pprof connections")
 
@@ -210,19 +394,53 @@ func main() {
 		flag.Usage()
 		return
 	}
+	var err error
+	config.finishwarnings, err = parseFinishWarnings(*finishwarnings)
+	if err != nil {
+		fmt.Printf("Invalid finish warnings: %s\n", err)
+		flag.Usage()
+		return
+	}
+	if config.historysize < 1 {
+		fmt.Println("History size must be at least 1")
+		flag.Usage()
+		return
+	}
+	if config.shutdowntimeout < 1 {
+		fmt.Println("Shutdown timeout must be at least 1 second")
+		flag.Usage()
+		return
+	}
+	if config.rateburst < 1 {
+		fmt.Println("Rate burst must be at least 1")
+		flag.Usage()
+		return
+	}
 
 	/* Start generic background goroutines */
 	go MeetingRemover()
+	go MeetingScheduler()
+	if config.notifychannel != "" {
+		go NotificationListener()
+	}
 
 	/* Start the profile listener if there is one */
 	if profilelisten != nil && *profilelisten != "" {
-		go doListenAndServe(*profilelisten, http.DefaultServeMux)
+		go doListenAndServe(*profilelisten, &http.Server{Handler: http.DefaultServeMux})
 	}
 
 	/* Setup the http handlers and listener */
 	mux := http.NewServeMux()
 	mux.HandleFunc("/ws/meeting/", wsHandler)
 	mux.HandleFunc("/__meetingstatus", StatusHandler)
+	mux.HandleFunc("/minutes/", MinutesHandler)
+
+	server := &http.Server{Handler: mux}
+	stopped := ShutdownOnSignal(server)
+
+	doListenAndServe(*listen, server)
 
-	doListenAndServe(*listen, mux)
+	/* Serving stops when we are shutting down, so finish that up */
+	ShutdownMeetings(<-stopped)
+	log.Printf("Shutdown complete")
 }
@@ -18,6 +18,9 @@ type MeetingStatus struct {
 	State               string         `json:"state"`
 	Members             []MemberStatus `json:"members"`
 	DisconnectedMembers []MemberStatus `json:"disconnectedmembers"`
+	Locked              bool           `json:"locked"`
+	Lobby               bool           `json:"lobby"`
+	WaitingMembers      []MemberStatus `json:"waitingmembers"`
 }
 type Status struct {
 	Upsince   time.Time `json:"upsince"`
@@ -49,10 +52,14 @@ func StatusHandler(w http.ResponseWriter, r *http.Request) {
 	status.Runtime.Cpus = runtime.NumCPU()
 	status.Runtime.Goversion = runtime.Version()
 
+	/* Meetings that stop while we ask them are skipped */
 	statchan := make(chan *MeetingStatus)
-	for _, m := range _meetings {
-		m.Statusquery <- statchan
-		status.Meetings = append(status.Meetings, <-statchan)
+	for _, m := range RunningMeetings() {
+		select {
+		case m.Statusquery <- statchan:
+			status.Meetings = append(status.Meetings, <-statchan)
+		case <-m.done:
+		}
 	}
 	if status.Meetings == nil {
 		status.Meetings = make([]*MeetingStatus, 0)
@@ -5,20 +5,48 @@ import (
 	_ "github.com/lib/pq"
 	"log"
 	"strings"
+	"sync/atomic"
 	"time"
 )
 
+/* Presence of a connected user */
+const (
+	PresenceActive = "active"
+	PresenceIdle   = "idle"
+	PresenceAway   = "away"
+)
+
+const (
+	/* Without any activity for this long, a user is idle and then away */
+	presenceIdleAfter = 2 * time.Minute
+	presenceAwayAfter = 10 * time.Minute
+	/* A user whose client has not answered a ping within this time is considered away */
+	presencePongTimeout = 20 * time.Second
+	/* Typing notifications are relayed at most this often per user */
+	typingInterval = 3 * time.Second
+)
+
 /* User data from db, and data "owned" by the meeting the user is in */
 type UserInfo struct {
-	keyid       int
-	authid      int
-	name        string
-	admin       bool
-	connected   bool
-	rejoined    bool
-	allowrejoin bool
-	proxyname   *string
-	color       string
+	keyid        int
+	authid       int
+	name         string
+	admin        bool
+	connected    bool
+	rejoined     bool
+	allowrejoin  bool
+	muted        bool
+	language     string
+	proxyname    *string
+	color        string
+	lastmessage  time.Time
+	presence     string
+	attendanceid int
+	roles        []string
+	permissions  Permission
+	/* Waiting in the lobby to be admitted, and since when */
+	waiting      bool
+	waitingsince time.Time
 }
 
 type User struct {
@@ -29,12 +57,22 @@ type User struct {
 	token        string
 	firstmessage int
 	remote       string
+	lasttyping   time.Time
+	/*
+	 * Activity timestamps in unix nanoseconds, written by the user
+	 * goroutines and read by the meeting to determine presence.
+	 */
+	lastactivity atomic.Int64
+	lastping     atomic.Int64
+	lastpong     atomic.Int64
+	/* Copy of Info.language for the user goroutines, stored by the meeting when the user is registered */
+	language atomic.Value
 	/* User data from db, and data "owned" by the meeting the user is in */
 	Info UserInfo
 }
 
 func newUser(meeting *Meeting, conn *websocket.Conn, token string, firstmessage int, remote string) *User {
-	return &User{
+	u := &User{
 		meeting:      meeting,
 		conn:         conn,
 		token:        token,
@@ -43,6 +81,8 @@ func newUser(meeting *Meeting, conn *websocket.Conn, token string, firstmessage
 		Disconnect:   make(chan string, 1),
 		remote:       remote,
 	}
+	u.lastactivity.Store(time.Now().UnixNano())
+	return u
 }
 
 func (u *User) Token() string {
@@ -57,15 +97,39 @@ func (u *User) Remote() string {
 	return u.remote
 }
 
+/* Determine the presence of the user from their activity and ping/pong round-trips */
+func (u *User) Presence() string {
+	now := time.Now()
+
+	ping := u.lastping.Load()
+	if ping > u.lastpong.Load() && now.Sub(time.Unix(0, ping)) > presencePongTimeout {
+		return PresenceAway
+	}
+
+	inactive := now.Sub(time.Unix(0, u.lastactivity.Load()))
+	if inactive > presenceAwayAfter {
+		return PresenceAway
+	}
+	if inactive > presenceIdleAfter {
+		return PresenceIdle
+	}
+	return PresenceActive
+}
+
 func (u *User) sendError(msg string) {
-	u.Send <- MakeError(msg)
+	/* Errors found before the user is registered are in the language of the meeting, which never changes */
+	lang, ok := u.language.Load().(string)
+	if !ok {
+		lang = u.meeting.language
+	}
+	u.Send <- MakeError(translate(lang, msg))
 }
 
-func (u *User) adminCheck(what string) {
-	/* Called in the user goroutine, but admin and name is set on startup and can never be changed, so we can safely read it */
-	if !u.Info.admin {
-		log.Printf("Attempt by non-admin %s to %s", u.Info.name, what)
-		u.sendError("Permission denied")
+/* Pass an action to the meeting, unless it has stopped and will never read it */
+func (u *User) sendAction(action MeetingUseraction) {
+	select {
+	case u.meeting.Useraction <- action:
+	case <-u.meeting.done:
 	}
 }
 
@@ -78,7 +142,43 @@ func (u *User) receiveMessage(data map[string]interface{}) {
 
 	/* Don't send an empty message */
 	if message != "" {
-		u.meeting.Useraction <- MeetingUseraction{action: ActionMessage, user: u, message: strings.TrimSpace(message)}
+		/* Replies and mentions are optional, and validated by the meeting */
+		replyto := 0
+		if r, ok := data["replyto"]; ok && r != nil {
+			rf, ok := r.(float64)
+			if !ok || rf < 1 {
+				u.sendError("Invalid message id to reply to")
+				return
+			}
+			replyto = int(rf)
+		}
+
+		var mentions []int
+		if rm, ok := data["mentions"]; ok && rm != nil {
+			rawmentions, ok := rm.([]interface{})
+			if !ok {
+				u.sendError("Invalid mentions in json")
+				return
+			}
+			if len(rawmentions) > 20 {
+				u.sendError("Too many mentions")
+				return
+			}
+			seen := make(map[int]bool)
+			for _, rawid := range rawmentions {
+				mf, ok := rawid.(float64)
+				if !ok {
+					u.sendError("Invalid mentions in json")
+					return
+				}
+				if !seen[int(mf)] {
+					seen[int(mf)] = true
+					mentions = append(mentions, int(mf))
+				}
+			}
+		}
+
+		u.sendAction(MeetingUseraction{action: ActionMessage, user: u, message: strings.TrimSpace(message), messageid: replyto, mentions: mentions})
 	}
 }
 
@@ -116,7 +216,7 @@ func (u *User) newPoll(data map[string]interface{}) {
 		answers = append(answers, aa)
 	}
 
-	u.meeting.Useraction <- MeetingUseraction{action: ActionNewPoll, message: question, answers: answers, minutes: int(minutes)}
+	u.sendAction(MeetingUseraction{action: ActionNewPoll, user: u, message: question, answers: answers, minutes: int(minutes)})
 }
 
 func (u *User) kickUser(data map[string]interface{}) {
@@ -132,7 +232,182 @@ func (u *User) kickUser(data map[string]interface{}) {
 		return
 	}
 
-	u.meeting.Useraction <- MeetingUseraction{action: ActionKickUser, user: u, targetuserid: int(targetuser), open: canrejoin}
+	u.sendAction(MeetingUseraction{action: ActionKickUser, user: u, targetuserid: int(targetuser), open: canrejoin})
+}
+
+func (u *User) muteUser(data map[string]interface{}, mute bool) {
+	targetuser, ok := data["user"].(float64)
+	if !ok {
+		u.sendError("Invalid user in json")
+		return
+	}
+
+	u.sendAction(MeetingUseraction{action: ActionMuteUser, user: u, targetuserid: int(targetuser), open: mute})
+}
+
+func (u *User) promoteUser(data map[string]interface{}, admin bool) {
+	targetuser, ok := data["user"].(float64)
+	if !ok {
+		u.sendError("Invalid user in json")
+		return
+	}
+
+	u.sendAction(MeetingUseraction{action: ActionSetAdmin, user: u, targetuserid: int(targetuser), open: admin})
+}
+
+/* Admit or reject a member waiting in the lobby, or everybody waiting */
+func (u *User) admitUser(data map[string]interface{}, admit bool) {
+	if all, _ := data["all"].(bool); all {
+		u.sendAction(MeetingUseraction{action: ActionAdmit, user: u, targetuserid: 0, open: admit})
+		return
+	}
+
+	targetuser, ok := data["user"].(float64)
+	if !ok || targetuser <= 0 {
+		u.sendError("Invalid user in json")
+		return
+	}
+
+	u.sendAction(MeetingUseraction{action: ActionAdmit, user: u, targetuserid: int(targetuser), open: admit})
+}
+
+func (u *User) receivePaperVote(data map[string]interface{}) {
+	targetuser, ok := data["user"].(float64)
+	if !ok {
+		u.sendError("Invalid user in json")
+		return
+	}
+	question, ok := data["question"].(string)
+	if !ok {
+		log.Println("Malformatted json in papervote")
+		return
+	}
+	vote, ok := data["vote"].(float64)
+	if !ok {
+		log.Println("Malformatted vote json in papervote")
+		return
+	}
+
+	u.sendAction(MeetingUseraction{action: ActionPaperVote, user: u, targetuserid: int(targetuser), message: question, vote: int(vote)})
+}
+
+func (u *User) setSlowMode(data map[string]interface{}) {
+	seconds, ok := data["seconds"].(float64)
+	if !ok || seconds < 0 {
+		u.sendError("Invalid or no seconds")
+		return
+	}
+
+	u.sendAction(MeetingUseraction{action: ActionSlowMode, user: u, seconds: int(seconds)})
+}
+
+func (u *User) requestHistory(data map[string]interface{}) {
+	before, ok := data["before"].(float64)
+	if !ok || before < 1 {
+		u.sendError("Invalid or no message id")
+		return
+	}
+
+	u.sendAction(MeetingUseraction{action: ActionHistory, user: u, messageid: int(before)})
+}
+
+func (u *User) pinMessage(data map[string]interface{}, pin bool) {
+	/* Pinning can be done on an existing message id, or on a new message text */
+	if message, ok := data["message"].(string); ok && pin {
+		message = strings.TrimSpace(message)
+		if message == "" {
+			u.sendError("Cannot pin an empty message")
+			return
+		}
+		u.sendAction(MeetingUseraction{action: ActionPinMessage, user: u, message: message, open: true})
+		return
+	}
+
+	messageid, ok := data["id"].(float64)
+	if !ok || messageid < 1 {
+		u.sendError("Invalid or no message id")
+		return
+	}
+
+	u.sendAction(MeetingUseraction{action: ActionPinMessage, user: u, messageid: int(messageid), open: pin})
+}
+
+func (u *User) receiveTyping() {
+	/* Throttled here in the user goroutine, since it is only a hint to others */
+	if time.Since(u.lasttyping) < typingInterval {
+		return
+	}
+	u.lasttyping = time.Now()
+
+	u.sendAction(MeetingUseraction{action: ActionTyping, user: u})
+}
+
+func (u *User) askQuestion(data map[string]interface{}) {
+	question, ok := data["question"].(string)
+	if !ok {
+		u.sendError("Invalid or no question")
+		return
+	}
+	question = strings.TrimSpace(question)
+	if question == "" {
+		return
+	}
+
+	u.sendAction(MeetingUseraction{action: ActionAskQuestion, user: u, message: question})
+}
+
+func (u *User) upvoteQuestion(data map[string]interface{}) {
+	questionid, ok := data["question"].(float64)
+	if !ok {
+		u.sendError("Invalid or no question id")
+		return
+	}
+	/* Upvote unless explicitly asked to remove the vote */
+	upvote := true
+	if uv, ok := data["upvote"].(bool); ok {
+		upvote = uv
+	}
+
+	u.sendAction(MeetingUseraction{action: ActionUpvoteQuestion, user: u, messageid: int(questionid), open: upvote})
+}
+
+func (u *User) markQuestion(data map[string]interface{}) {
+	questionid, ok := data["question"].(float64)
+	if !ok {
+		u.sendError("Invalid or no question id")
+		return
+	}
+
+	state := -1
+	if s, ok := data["state"].(string); ok {
+		for k, v := range QuestionStateMap {
+			if v == s {
+				state = k
+			}
+		}
+	}
+	if state < 0 {
+		u.sendError("Invalid or no question state")
+		return
+	}
+
+	u.sendAction(MeetingUseraction{action: ActionMarkQuestion, user: u, messageid: int(questionid), vote: state})
+}
+
+func (u *User) setAgendaItem(data map[string]interface{}) {
+	/* Either jump to a specific item, or advance to the next one */
+	if next, ok := data["next"].(bool); ok && next {
+		u.sendAction(MeetingUseraction{action: ActionAgendaItem, user: u})
+		return
+	}
+
+	item, ok := data["item"].(float64)
+	if !ok || item < 1 {
+		u.sendError("Invalid or no agenda item")
+		return
+	}
+
+	u.sendAction(MeetingUseraction{action: ActionAgendaItem, user: u, messageid: int(item)})
 }
 
 func (u *User) receiveVote(data map[string]interface{}) {
@@ -147,7 +422,7 @@ func (u *User) receiveVote(data map[string]interface{}) {
 		return
 	}
 
-	u.meeting.Useraction <- MeetingUseraction{action: ActionVote, message: question, vote: int(vote), user: u}
+	u.sendAction(MeetingUseraction{action: ActionVote, message: question, vote: int(vote), user: u})
 }
 
 func (u *User) receiveData(j interface{}) {
@@ -168,31 +443,126 @@ func (u *User) receiveData(j interface{}) {
 		u.receiveMessage(root)
 	case "vote":
 		u.receiveVote(root)
+	case "history":
+		u.requestHistory(root)
+	case "typing":
+		u.receiveTyping()
+	case "question":
+		u.askQuestion(root)
+	case "upvote":
+		u.upvoteQuestion(root)
 	case "open":
 		{
-			u.adminCheck("open/close meeting")
-			u.meeting.Useraction <- MeetingUseraction{action: ActionOpenFinish, user: u, open: true}
+			u.sendAction(MeetingUseraction{action: ActionOpenFinish, user: u, open: true})
 		}
 	case "finish":
 		{
-			u.adminCheck("open/close meeting")
-			u.meeting.Useraction <- MeetingUseraction{action: ActionOpenFinish, user: u, open: false}
+			u.sendAction(MeetingUseraction{action: ActionOpenFinish, user: u, open: false})
 		}
 	case "newpoll":
 		{
-			u.adminCheck("create new peoll")
 			u.newPoll(root)
 		}
 	case "abortpoll":
 		{
-			u.adminCheck("abort running poll")
-			u.meeting.Useraction <- MeetingUseraction{action: ActionAbortPoll, user: u}
+			u.sendAction(MeetingUseraction{action: ActionAbortPoll, user: u})
 		}
 	case "kick":
 		{
-			u.adminCheck("kick another user")
 			u.kickUser(root)
 		}
+	case "mute":
+		{
+			u.muteUser(root, true)
+		}
+	case "unmute":
+		{
+			u.muteUser(root, false)
+		}
+	case "papervote":
+		{
+			u.receivePaperVote(root)
+		}
+	case "promote":
+		{
+			u.promoteUser(root, true)
+		}
+	case "demote":
+		{
+			u.promoteUser(root, false)
+		}
+	case "slowmode":
+		{
+			u.setSlowMode(root)
+		}
+	case "pin":
+		{
+			u.pinMessage(root, true)
+		}
+	case "unpin":
+		{
+			u.pinMessage(root, false)
+		}
+	case "openqa":
+		{
+			u.sendAction(MeetingUseraction{action: ActionQAOpenClose, user: u, open: true})
+		}
+	case "closeqa":
+		{
+			u.sendAction(MeetingUseraction{action: ActionQAOpenClose, user: u, open: false})
+		}
+	case "markquestion":
+		{
+			u.markQuestion(root)
+		}
+	case "agenda":
+		{
+			u.setAgendaItem(root)
+		}
+	case "recess":
+		{
+			/* The resume time is optional */
+			minutes, _ := root["minutes"].(float64)
+			u.sendAction(MeetingUseraction{action: ActionRecess, user: u, minutes: int(minutes)})
+		}
+	case "requirequorum":
+		{
+			required, ok := root["required"].(bool)
+			if !ok {
+				log.Println("Malformatted json in requirequorum")
+				return
+			}
+			u.sendAction(MeetingUseraction{action: ActionRequireQuorum, user: u, open: required})
+		}
+	case "attendance":
+		{
+			u.sendAction(MeetingUseraction{action: ActionAttendance, user: u})
+		}
+	case "lobby":
+		{
+			enabled, ok := root["enabled"].(bool)
+			if !ok {
+				log.Println("Malformatted json in lobby")
+				return
+			}
+			u.sendAction(MeetingUseraction{action: ActionLobby, user: u, open: enabled})
+		}
+	case "lock":
+		{
+			u.sendAction(MeetingUseraction{action: ActionLock, user: u, open: true})
+		}
+	case "unlock":
+		{
+			u.sendAction(MeetingUseraction{action: ActionLock, user: u, open: false})
+		}
+	case "admit":
+		{
+			u.admitUser(root, true)
+		}
+	case "reject":
+		{
+			u.admitUser(root, false)
+		}
 	default:
 		log.Println("Unknown object type ", t)
 	}
@@ -208,6 +578,7 @@ func (u *User) writer() {
 	defer func() {
 		ticker.Stop()
 		u.conn.Close()
+		_connections.Add(-1)
 		log.Println("Connection closed in writing for token ", u.token)
 	}()
 
@@ -252,13 +623,17 @@ func (u *User) writer() {
 			if err := u.conn.WriteMessage(websocket.PingMessage, nil); err != nil {
 				return
 			}
+			u.lastping.Store(time.Now().UnixNano())
 		}
 	}
 }
 
 func (u *User) reader() {
 	defer func() {
-		u.meeting.Unregister <- u
+		select {
+		case u.meeting.Unregister <- u:
+		case <-u.meeting.done:
+		}
 		u.conn.Close()
 		log.Println("Connection closed in reading for token ", u.token)
 	}()
@@ -267,6 +642,7 @@ func (u *User) reader() {
 	u.conn.SetReadDeadline(time.Now().Add(90 * time.Second))
 
 	u.conn.SetPongHandler(func(string) error {
+		u.lastpong.Store(time.Now().UnixNano())
 		u.conn.SetReadDeadline(time.Now().Add(90 * time.Second))
 		return nil
 	})
@@ -281,6 +657,7 @@ func (u *User) reader() {
 			}
 			break
 		}
+		u.lastactivity.Store(time.Now().UnixNano())
 		u.receiveData(data)
 	}
 }
@@ -18,35 +18,148 @@ type ErrorMsg struct {
 
 /* A new message posted by somebody */
 type msgMessage struct {
-	Id       int    `json:"id"`
-	Time     string `json:"time"`
-	Date     string `json:"date"`
-	From     int64  `json:"from"`
-	FromName string `json:"fromname"`
-	Color    string `json:"color"`
-	Message  string `json:"message"`
+	Id         int       `json:"id"`
+	Time       string    `json:"time"`
+	Date       string    `json:"date"`
+	Timestamp  string    `json:"timestamp"`
+	From       int64     `json:"from"`
+	FromName   string    `json:"fromname"`
+	Color      string    `json:"color"`
+	Message    string    `json:"message"`
+	Event      *msgEvent `json:"event,omitempty"`
+	ReplyTo    int       `json:"replyto,omitempty"`
+	Mentions   []int     `json:"mentions,omitempty"`
+	AgendaItem int       `json:"agendaitem,omitempty"`
+}
+
+/* Structured description of a system message */
+type msgEvent struct {
+	Type   string      `json:"type"`
+	Params EventParams `json:"params"`
+}
+
+/*
+ * Set the time fields of a message. Time and date are for display in the
+ * specified timezone, and the timestamp is always in UTC.
+ */
+func (msg *msgMessage) setTime(t time.Time, loc *time.Location) {
+	lt := t.In(loc)
+	msg.Time = lt.Format("15:04:05")
+	msg.Date = lt.Format("2006-01-02")
+	msg.Timestamp = t.UTC().Format(time.RFC3339)
+}
+
+/* A page of older messages, requested by the client */
+type msgHistory struct {
+	Before   int          `json:"before"`
+	Messages []msgMessage `json:"messages"`
+	More     bool         `json:"more"`
 }
 
 /* Status of the meeting */
 type msgMeetingState struct {
-	Isopen     bool `json:"isopen"`
-	Isfinished bool `json:"isfinished"`
+	Isopen         bool   `json:"isopen"`
+	Isfinished     bool   `json:"isfinished"`
+	Isrecess       bool   `json:"isrecess"`
+	Resume         string `json:"resume,omitempty"`
+	Slowmode       int    `json:"slowmode"`
+	Qaopen         bool   `json:"qaopen"`
+	Timezone       string `json:"timezone"`
+	Quorum         int    `json:"quorum"`
+	Quorumrequired bool   `json:"quorumrequired"`
+	Present        int    `json:"present"`
+	Quorate        bool   `json:"quorate"`
+	Lobby          bool   `json:"lobby"`
+	Locked         bool   `json:"locked"`
 }
 
 /* Status of the current poll */
 type msgPollStatus struct {
-	Question string   `json:"question"`
-	Answers  []string `json:"answers"`
-	Tally    [5]int   `json:"tally"`
-	Voted    []int    `json:"voted"`
+	Question   string   `json:"question"`
+	Answers    []string `json:"answers"`
+	Tally      [5]int   `json:"tally"`
+	Voted      []int    `json:"voted"`
+	AgendaItem int      `json:"agendaitem,omitempty"`
+}
+
+/* The agenda of the meeting, and the item currently being discussed */
+type msgAgendaItem struct {
+	Id    int    `json:"id"`
+	Title string `json:"title"`
+}
+type msgAgenda struct {
+	Items   []msgAgendaItem `json:"items"`
+	Current int             `json:"current"`
+}
+
+/* Questions in Q&A mode */
+type msgQuestion struct {
+	Id       int    `json:"id"`
+	From     int    `json:"from"`
+	FromName string `json:"fromname"`
+	Question string `json:"question"`
+	Votes    int    `json:"votes"`
+	State    string `json:"state"`
+	Voters   []int  `json:"voters"`
+}
+type msgQuestions struct {
+	Questions []msgQuestion `json:"questions"`
+}
+
+/* Attendance register, for admins */
+type msgAttendance struct {
+	Id        int    `json:"id"`
+	Name      string `json:"name"`
+	Proxy     string `json:"proxy"`
+	Connected bool   `json:"connected"`
+	Seconds   int    `json:"seconds"`
+	Polls     []int  `json:"polls"`
+}
+type msgAttendanceList struct {
+	Members []msgAttendance `json:"members"`
+}
+
+/* Members waiting in the lobby, for moderators */
+type msgLobbyMember struct {
+	Id    int    `json:"id"`
+	Name  string `json:"name"`
+	Proxy string `json:"proxy"`
+	Since string `json:"since"`
+}
+type msgLobby struct {
+	Members []msgLobbyMember `json:"members"`
+}
+
+/* Sent to a member held in the lobby, instead of the meeting itself */
+type msgWaiting struct {
+	Id   int    `json:"id"`
+	Name string `json:"name"`
 }
 
 /* Users currently in the meeting */
 type msgUser struct {
-	Name  string `json:"name"`
-	Color string `json:"color"`
-	Id    int    `json:"id"`
+	Name     string   `json:"name"`
+	Color    string   `json:"color"`
+	Id       int      `json:"id"`
+	Presence string   `json:"presence"`
+	Admin    bool     `json:"admin"`
+	Roles    []string `json:"roles"`
+	Muted    bool     `json:"muted,omitempty"`
 }
+
+/* Information about the connected user themselves */
+type msgSelf struct {
+	Id          int      `json:"id"`
+	Admin       bool     `json:"admin"`
+	Roles       []string `json:"roles"`
+	Permissions []string `json:"permissions"`
+}
+
+/* A user is typing a message */
+type msgTyping struct {
+	Id int `json:"id"`
+}
+
 type msgUsers struct {
 	Users []msgUser `json:"users"`
 }
@@ -64,15 +177,14 @@ func MakeError(message string) ErrorMsg {
 }
 
 func DisconnectMessage(msg string) Msg {
-	time := time.Now()
 	data := msgMessage{
 		Id:       -1,
-		Time:     time.Format("15:04:05"),
-		Date:     time.Format("2006-01-02"),
 		Message:  msg,
 		From:     -1,
 		FromName: "",
 	}
+	/* Not necessarily attached to a meeting, so use the server timezone */
+	data.setTime(time.Now(), time.Local)
 
 	return MakeMessage("disconnect", data)
 }
@@ -82,6 +194,8 @@ func MakeMeetingState(state int) msgMeetingState {
 		return msgMeetingState{Isopen: true, Isfinished: false}
 	} else if state == MeetingStateFinished {
 		return msgMeetingState{Isopen: false, Isfinished: true}
+	} else if state == MeetingStateRecess {
+		return msgMeetingState{Isopen: false, Isfinished: false, Isrecess: true}
 	} else {
 		return msgMeetingState{Isopen: false, Isfinished: false}
 	}